@@ -0,0 +1,132 @@
+package xm
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// Base64 is written as xs:base64Binary in attributes and content.
+type Base64 []byte
+
+// Base64Lines is written like Base64, but content is wrapped into lines of 76
+// characters. The lines are indented to the current block level, unless
+// whitespace is preserved in the enclosing tag. Attributes are not wrapped.
+type Base64Lines []byte
+
+// Hex is written as xs:hexBinary in attributes and content.
+type Hex []byte
+
+// Base64Stream reads data from R until EOF and writes it into content as
+// xs:base64Binary. The data is encoded and written chunk by chunk, without
+// reading it into memory at once. With Wrap, lines are wrapped like
+// Base64Lines. Read errors are reported with ErrPrinter.Fail.
+type Base64Stream struct {
+	R    io.Reader
+	Wrap bool
+}
+
+// HexStream works like Base64Stream, but writes data as xs:hexBinary.
+type HexStream struct {
+	R io.Reader
+}
+
+// base64LineLen is the length of wrapped base64 lines, as in MIME.
+const base64LineLen = 76
+
+// streamChunk is the size of chunks read by streams, it is a multiple of 3
+// (no padding within the stream) and of 57 (base64 input of a single line).
+const streamChunk = 57 * 3 * 24
+
+// MarshalXAttr implements AttrMarshaler.
+func (b Base64) MarshalXAttr() (RawAttr, bool) {
+	return RawAttr(base64.StdEncoding.EncodeToString(b)), len(b) > 0
+}
+
+// MarshalXCont implements ContMarshaler.
+func (b Base64) MarshalXCont(p Printer) {
+	if len(b) > 0 {
+		p.Content(RawCont(base64.StdEncoding.EncodeToString(b)))
+	}
+}
+
+// MarshalXAttr implements AttrMarshaler.
+func (b Base64Lines) MarshalXAttr() (RawAttr, bool) {
+	return Base64(b).MarshalXAttr()
+}
+
+// MarshalXCont implements ContMarshaler.
+func (b Base64Lines) MarshalXCont(p Printer) {
+	if len(b) > 0 {
+		p.Content(RawCont(wrapLines(base64.StdEncoding.EncodeToString(b), base64LineLen)))
+	}
+}
+
+// MarshalXAttr implements AttrMarshaler.
+func (b Hex) MarshalXAttr() (RawAttr, bool) {
+	return RawAttr(strings.ToUpper(hex.EncodeToString(b))), len(b) > 0
+}
+
+// MarshalXCont implements ContMarshaler.
+func (b Hex) MarshalXCont(p Printer) {
+	if len(b) > 0 {
+		p.Content(RawCont(strings.ToUpper(hex.EncodeToString(b))))
+	}
+}
+
+// MarshalXCont implements ContMarshaler.
+func (s Base64Stream) MarshalXCont(p Printer) {
+	streamChunks(p, s.R, func(chunk []byte, first bool) string {
+		enc := base64.StdEncoding.EncodeToString(chunk)
+		if !s.Wrap {
+			return enc
+		}
+		enc = wrapLines(enc, base64LineLen)
+		if !first {
+			enc = "\n" + enc
+		}
+		return enc
+	})
+}
+
+// MarshalXCont implements ContMarshaler.
+func (s HexStream) MarshalXCont(p Printer) {
+	streamChunks(p, s.R, func(chunk []byte, first bool) string {
+		return strings.ToUpper(hex.EncodeToString(chunk))
+	})
+}
+
+// streamChunks reads r in chunks of streamChunk bytes and writes them into
+// content with encode.
+func streamChunks(p Printer, r io.Reader, encode func(chunk []byte, first bool) string) {
+	buf := make([]byte, streamChunk)
+	for first := true; printerErr(p) == nil; first = false {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			p.Content(RawCont(encode(buf[:n], first)))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return
+		} else if err != nil {
+			fail(p, err)
+			return
+		}
+	}
+}
+
+// wrapLines splits s into lines of n characters.
+func wrapLines(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	b := strings.Builder{}
+	b.Grow(len(s) + len(s)/n)
+	for len(s) > n {
+		b.WriteString(s[:n])
+		b.WriteByte('\n')
+		s = s[n:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,104 @@
+package xm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestBinary(t *testing.T) {
+	type doc struct {
+		XMName struct{} `xm:"doc"`
+		Sum    Hex      `xm:"sum,attr"`
+		Data   Base64   `xm:"data"`
+	}
+	blob := bytes.Repeat([]byte{0xff, 0x00, 0x7f}, 30) // 90 bytes, 120 base64 chars
+	tests := []struct {
+		name string
+		arg  any
+		want string
+	}{
+		{"base64", []any{Attr("a", Base64("hi?")), Base64("hi?")}, "<x a='aGk/'>aGk/</x>"},
+		{"hex", []any{Attr("a", Hex{0xde, 0xad}), Hex{0xbe, 0xef}}, "<x a='DEAD'>BEEF</x>"},
+		{"empty", []any{func(w AttrWriter) { w.OptAttr("a", Base64{}) }, Base64{}}, "<x/>"},
+		{"lines", Tag("y", Base64Lines(blob)),
+			"<x>\n  <y>" + strings.Repeat("/wB/", 19) + "\n    " + strings.Repeat("/wB/", 11) + "</y>\n</x>"},
+		{"struct", doc{Sum: Hex{1}, Data: Base64{1}}, "<x sum='01'>\n  <data>AQ==</data>\n</x>"},
+		{"stream", Base64Stream{R: iotest.OneByteReader(bytes.NewReader(blob))}, "<x>" + strings.Repeat("/wB/", 30) + "</x>"},
+		{"hex stream", HexStream{R: strings.NewReader("\x01\x02")}, "<x>0102</x>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			var args []any
+			if a, ok := tt.arg.([]any); ok {
+				args = a
+			} else {
+				args = []any{tt.arg}
+			}
+			if err := Render(&buf, func(w Writer) { w.Tag("x", args...) }); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("got  %q\nwant %q", got, tt.want+"\n")
+			}
+		})
+	}
+}
+
+func TestBase64Stream(t *testing.T) {
+	data := make([]byte, 3*streamChunk+100)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	for _, wrap := range []bool{false, true} {
+		var chunks []int
+		buf := strings.Builder{}
+		p := NewPrinter(Indent2Spaces, func(b []byte) { buf.Write(b) }, nil, WithErrorMode())
+		w := NewWriter(p)
+		w.Tag("x", func(p Printer) {
+			Base64Stream{R: io.TeeReader(bytes.NewReader(data), writerFunc(func(b []byte) {
+				chunks = append(chunks, len(b))
+			})), Wrap: wrap}.MarshalXCont(p)
+		})
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		want := Base64(data)
+		var enc string
+		if wrap {
+			enc = wrapLines(string(must(want.MarshalXAttr())), base64LineLen)
+			enc = strings.ReplaceAll(enc, "\n", "\n  ")
+		} else {
+			enc = string(must(want.MarshalXAttr()))
+		}
+		if got := buf.String(); got != "<x>"+enc+"</x>\n" {
+			t.Errorf("wrap=%v: unexpected output %.60q...", wrap, got)
+		}
+		if len(chunks) < 4 {
+			t.Errorf("wrap=%v: got %d reads, want streaming", wrap, len(chunks))
+		}
+	}
+
+	rerr := errors.New("read failed")
+	err := Render(io.Discard, func(w Writer) {
+		w.Tag("x", Base64Stream{R: iotest.ErrReader(rerr)})
+	})
+	if !errors.Is(err, rerr) {
+		t.Errorf("got %v, want %v", err, rerr)
+	}
+}
+
+type writerFunc func([]byte)
+
+func (f writerFunc) Write(b []byte) (int, error) {
+	f(b)
+	return len(b), nil
+}
+
+func must(r RawAttr, _ bool) RawAttr {
+	return r
+}
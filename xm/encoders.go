@@ -0,0 +1,104 @@
+package xm
+
+import (
+	"reflect"
+	"sync"
+)
+
+// encoders holds custom encoders for specific types.
+type encoders struct {
+	attr map[reflect.Type]func(reflect.Value) (RawAttr, bool)
+	cont map[reflect.Type]func(Printer, reflect.Value)
+}
+
+var (
+	global_mu  sync.RWMutex
+	global_enc encoders
+)
+
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+func (e *encoders) setAttr(t reflect.Type, f func(reflect.Value) (RawAttr, bool)) {
+	if e.attr == nil {
+		e.attr = map[reflect.Type]func(reflect.Value) (RawAttr, bool){}
+	}
+	e.attr[t] = f
+}
+
+func (e *encoders) setCont(t reflect.Type, f func(Printer, reflect.Value)) {
+	if e.cont == nil {
+		e.cont = map[reflect.Type]func(Printer, reflect.Value){}
+	}
+	e.cont[t] = f
+}
+
+func attrEncoder[T any](f func(T) (RawAttr, bool)) func(reflect.Value) (RawAttr, bool) {
+	return func(v reflect.Value) (RawAttr, bool) {
+		return f(v.Interface().(T))
+	}
+}
+
+func contEncoder[T any](f func(Printer, T)) func(Printer, reflect.Value) {
+	return func(p Printer, v reflect.Value) {
+		f(p, v.Interface().(T))
+	}
+}
+
+// RegisterAttrEncoder registers f as the global encoder for attribute values
+// of type T. The bool part returned by f reports whether the value is
+// non-empty, see AttrWriter.OptAttr. Encoders are matched by the exact type
+// of values, including pointed-to values, and take precedence over marshaling
+// interfaces implemented by T and over the built-in handling of types like
+// string and bool. Encoders registered with WithAttrEncoder take
+// precedence over global ones.
+func RegisterAttrEncoder[T any](f func(T) (RawAttr, bool)) {
+	global_mu.Lock()
+	defer global_mu.Unlock()
+	global_enc.setAttr(typeOf[T](), attrEncoder(f))
+}
+
+// RegisterContEncoder registers f as the global encoder for content values of
+// type T, see RegisterAttrEncoder.
+func RegisterContEncoder[T any](f func(Printer, T)) {
+	global_mu.Lock()
+	defer global_mu.Unlock()
+	global_enc.setCont(typeOf[T](), contEncoder(f))
+}
+
+// WithAttrEncoder registers f as the encoder for attribute values of type T
+// within the Writer, see RegisterAttrEncoder.
+func WithAttrEncoder[T any](f func(T) (RawAttr, bool)) WriterOption {
+	return func(w *writer_impl) {
+		w.enc.setAttr(typeOf[T](), attrEncoder(f))
+	}
+}
+
+// WithContEncoder registers f as the encoder for content values of type T
+// within the Writer, see RegisterAttrEncoder.
+func WithContEncoder[T any](f func(Printer, T)) WriterOption {
+	return func(w *writer_impl) {
+		w.enc.setCont(typeOf[T](), contEncoder(f))
+	}
+}
+
+// attrEncoder returns the encoder for attribute values of type t, or nil.
+func (w *writer_impl) attrEncoder(t reflect.Type) func(reflect.Value) (RawAttr, bool) {
+	if f := w.enc.attr[t]; f != nil {
+		return f
+	}
+	global_mu.RLock()
+	defer global_mu.RUnlock()
+	return global_enc.attr[t]
+}
+
+// contEncoder returns the encoder for content values of type t, or nil.
+func (w *writer_impl) contEncoder(t reflect.Type) func(Printer, reflect.Value) {
+	if f := w.enc.cont[t]; f != nil {
+		return f
+	}
+	global_mu.RLock()
+	defer global_mu.RUnlock()
+	return global_enc.cont[t]
+}
@@ -0,0 +1,92 @@
+package xm
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type kelvin struct{ deg float64 }
+
+func init() {
+	RegisterAttrEncoder(func(c kelvin) (RawAttr, bool) {
+		return RawAttr(strconv.FormatFloat(c.deg, 'f', -1, 64) + "K"), true
+	})
+	RegisterContEncoder(func(p Printer, c kelvin) {
+		p.Content(RawCont(strconv.FormatFloat(c.deg, 'f', -1, 64) + " K"))
+	})
+}
+
+func ExampleWithAttrEncoder() {
+	day := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	p := NewStreamPrinter(os.Stdout, Indent2Spaces, nil)
+	w := NewWriter(p,
+		WithAttrEncoder(func(t time.Time) (RawAttr, bool) {
+			return RawAttr(t.Format("2006-01-02")), !t.IsZero()
+		}),
+		WithContEncoder(func(p Printer, t time.Time) {
+			p.Content(RawCont(t.Format(time.Kitchen)))
+		}))
+	w.Tag("event", Attr("date", day), func(w AttrWriter) { w.OptAttr("until", time.Time{}) }, day)
+	p.Close()
+	// Output:
+	// <event date='2024-03-01'>12:30PM</event>
+}
+
+func TestEncoders(t *testing.T) {
+	type reading struct {
+		XMName struct{} `xm:"reading"`
+		Min    kelvin   `xm:"min,attr"`
+		Max    *kelvin  `xm:"max,attr"`
+		Temp   kelvin   `xm:"temp"`
+		Log    []kelvin `xm:"log"`
+	}
+	c := kelvin{21.5}
+	tests := []struct {
+		name string
+		opts []WriterOption
+		f    func(w Writer)
+		want string
+	}{
+		{"global attr", nil, func(w Writer) { w.Tag("x", Attr("t", c), Attr("p", &c)) }, "<x t='21.5K' p='21.5K'/>"},
+		{"global cont", nil, func(w Writer) { w.Tag("x", c, &c) }, "<x>21.5 K21.5 K</x>"},
+		{"struct fields", nil, func(w Writer) {
+			w.Cont(reading{Min: kelvin{-1}, Max: &c, Temp: c, Log: []kelvin{{1}, {2}}})
+		}, "<reading min='-1K' max='21.5K'>\n  <temp>21.5 K</temp>\n  <log>1 K</log>\n  <log>2 K</log>\n</reading>"},
+		{"list", nil, func(w Writer) { w.Tag("x", Attr("t", []kelvin{{1}, {2}})) }, "<x t='1K 2K'/>"},
+		{"writer overrides global",
+			[]WriterOption{
+				WithAttrEncoder(func(c kelvin) (RawAttr, bool) { return RawAttr("warm"), c.deg > 20 }),
+				WithContEncoder(func(p Printer, c kelvin) { p.Content(RawCont("cold")) }),
+			},
+			func(w Writer) {
+				w.Tag("x", Attr("a", c), func(w AttrWriter) { w.OptAttr("b", kelvin{0}) }, c)
+			}, "<x a='warm'>cold</x>"},
+		{"builtin type", []WriterOption{WithAttrEncoder(func(v int) (RawAttr, bool) { return RawAttr("#" + strconv.Itoa(v)), true })},
+			func(w Writer) { w.Tag("x", Attr("n", 5), Attr("m", int64(5))) }, "<x n='#5' m='5'/>"},
+		{"bool and string", []WriterOption{
+			WithAttrEncoder(func(v bool) (RawAttr, bool) { return RawAttr(map[bool]string{true: "Y", false: "N"}[v]), true }),
+			WithContEncoder(func(p Printer, s string) { p.Content(RawCont(strings.ToUpper(s))) }),
+		}, func(w Writer) {
+			w.Tag("x", Attr("b", false), "text", struct {
+				On   bool   `xm:"on,attr"`
+				Text string `xm:",chardata"`
+			}{true, " and field"})
+		}, "<x b='N' on='Y'>TEXT AND FIELD</x>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			p := NewStreamPrinter(&buf, Indent2Spaces, nil, WithErrorMode())
+			tt.f(NewWriter(p, tt.opts...))
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("got  %q\nwant %q", got, tt.want+"\n")
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package xm
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DurationFormat selects formatting of time.Duration values.
+type DurationFormat int
+
+const (
+	// Accepted values for DurationFormat:
+	DurationNanos   = DurationFormat(iota) // integer number of nanoseconds (default)
+	DurationString                         // time.Duration.String(), e.g. 1h30m0s
+	DurationISO8601                        // xs:duration, e.g. PT1H30M
+)
+
+// valueFormat configures formatting of scalar values within a Writer.
+type valueFormat struct {
+	float_fmt   byte
+	float_prec  int
+	int_base    int
+	bool_true   string
+	bool_false  string
+	time_layout string
+	duration    DurationFormat
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// format returns the writer's value format, allocating it with the defaults
+// if necessary.
+func (w *writer_impl) format() *valueFormat {
+	if w.vf == nil {
+		w.vf = &valueFormat{
+			float_fmt:  'g',
+			float_prec: -1,
+			int_base:   10,
+			bool_true:  "true",
+			bool_false: "false",
+		}
+	}
+	return w.vf
+}
+
+// WithFloatFormat sets the format and the precision that are used for
+// floating point values, see strconv.FormatFloat. The default is 'g' with
+// precision -1. Use 'f' to avoid exponent notation, for example 'f' with
+// precision 2 writes 12.5 as 12.50.
+func WithFloatFormat(fmt byte, prec int) WriterOption {
+	return func(w *writer_impl) {
+		w.format().float_fmt = fmt
+		w.format().float_prec = prec
+	}
+}
+
+var ErrInvalidIntBase = errors.New("xml writer: integer base must be in the range 2..36")
+
+// WithIntBase sets the base that is used for integer values, 10 by default.
+// It panics with ErrInvalidIntBase if base is not in the range 2..36, see
+// strconv.FormatInt.
+func WithIntBase(base int) WriterOption {
+	if base < 2 || base > 36 {
+		panic(ErrInvalidIntBase)
+	}
+	return func(w *writer_impl) {
+		w.format().int_base = base
+	}
+}
+
+// WithBoolFormat sets the strings that are written for boolean values,
+// "true" and "false" by default. For example, xs:boolean also accepts "1" and
+// "0".
+func WithBoolFormat(t, f string) WriterOption {
+	return func(w *writer_impl) {
+		w.format().bool_true = t
+		w.format().bool_false = f
+	}
+}
+
+// WithTimeFormat sets the layout that is used for time.Time values, see
+// time.Time.Format. By default, time.Time values are written with their
+// MarshalText method, which matches xs:dateTime. Use "2006-01-02" for xs:date.
+func WithTimeFormat(layout string) WriterOption {
+	return func(w *writer_impl) {
+		w.format().time_layout = layout
+	}
+}
+
+// WithDurationFormat selects formatting of time.Duration values.
+func WithDurationFormat(d DurationFormat) WriterOption {
+	return func(w *writer_impl) {
+		w.format().duration = d
+	}
+}
+
+// coreToStr converts supported core types to string with the writer's value
+// format. Named types, including time.Time and time.Duration, are left to
+// marshal_attr and marshal_content, which check marshaling interfaces first.
+func (w *writer_impl) coreToStr(val any) (string, bool) {
+	if w.vf == nil {
+		return coreToStr(val)
+	}
+	if t := reflect.TypeOf(val); t == nil || t.PkgPath() != "" {
+		return "", false
+	}
+	return w.vf.format(reflect.ValueOf(val))
+}
+
+// reflectCoreToStr works like coreToStr for reflected values.
+func (w *writer_impl) reflectCoreToStr(val reflect.Value) (string, bool) {
+	if w.vf == nil {
+		return reflectCoreToStr(val)
+	}
+	return w.vf.format(val)
+}
+
+// attrStr converts a formatted value into an attribute value, strings that
+// come from the writer's value format are escaped.
+func (w *writer_impl) attrStr(s string) RawAttr {
+	if w.vf == nil {
+		return RawAttr(s)
+	}
+	return scrambleAttr(w.p, s)
+}
+
+// contStr converts a formatted value into content, see attrStr.
+func (w *writer_impl) contStr(s string) RawCont {
+	if w.vf == nil {
+		return RawCont(s)
+	}
+	return scrambleCont(w.p, s)
+}
+
+// formatTime formats time.Time and time.Duration values with the writer's
+// value format. It returns false for other values, and for values that are
+// written with default formatting.
+func (w *writer_impl) formatTime(val reflect.Value) (string, bool) {
+	if w.vf == nil {
+		return "", false
+	}
+	return w.vf.formatTime(val)
+}
+
+func (f *valueFormat) format(val reflect.Value) (string, bool) {
+	if s, ok := f.formatTime(val); ok {
+		return s, true
+	}
+	switch val.Kind() {
+	case reflect.Bool:
+		if val.Bool() {
+			return f.bool_true, true
+		}
+		return f.bool_false, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(val.Int(), f.int_base), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(val.Uint(), f.int_base), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(val.Float(), f.float_fmt, f.float_prec, val.Type().Bits()), true
+	default:
+		return "", false
+	}
+}
+
+func (f *valueFormat) formatTime(val reflect.Value) (string, bool) {
+	switch val.Type() {
+	case timeType:
+		if f.time_layout == "" || !val.CanInterface() {
+			return "", false
+		}
+		return val.Interface().(time.Time).Format(f.time_layout), true
+	case durationType:
+		switch f.duration {
+		case DurationString:
+			return time.Duration(val.Int()).String(), true
+		case DurationISO8601:
+			return isoDuration(time.Duration(val.Int())), true
+		}
+	}
+	return "", false
+}
+
+// isoDuration formats d as xs:duration, using hours, minutes, and seconds.
+func isoDuration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	b := make([]byte, 0, 24)
+	u := uint64(d)
+	if d < 0 {
+		b = append(b, '-')
+		u = -u
+	}
+	b = append(b, 'P', 'T')
+	if h := u / uint64(time.Hour); h > 0 {
+		b = append(strconv.AppendUint(b, h, 10), 'H')
+		u -= h * uint64(time.Hour)
+	}
+	if m := u / uint64(time.Minute); m > 0 {
+		b = append(strconv.AppendUint(b, m, 10), 'M')
+		u -= m * uint64(time.Minute)
+	}
+	if u > 0 {
+		b = strconv.AppendUint(b, u/uint64(time.Second), 10)
+		if ns := u % uint64(time.Second); ns > 0 {
+			frac := strconv.FormatUint(ns+uint64(time.Second), 10)[1:] // zero padded
+			b = append(b, '.')
+			b = append(b, strings.TrimRight(frac, "0")...)
+		}
+		b = append(b, 'S')
+	}
+	return string(b)
+}
@@ -0,0 +1,136 @@
+package xm
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValueFormat(t *testing.T) {
+	type rec struct {
+		XMName struct{}      `xm:"rec"`
+		Price  float64       `xm:"price,attr"`
+		Ok     bool          `xm:"ok,attr"`
+		At     *time.Time    `xm:"at"`
+		Wait   time.Duration `xm:"wait"`
+	}
+	at := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	r := rec{Price: 12.5, Ok: true, At: &at, Wait: 90*time.Minute + 500*time.Millisecond}
+	tests := []struct {
+		name string
+		opts []WriterOption
+		want string
+	}{
+		{"defaults", nil,
+			"<rec price='12.5' ok='true'>\n  <at>2024-03-01T12:30:00Z</at>\n  <wait>5400500000000</wait>\n</rec>"},
+		{"custom", []WriterOption{WithFloatFormat('f', 2), WithBoolFormat("1", "0"),
+			WithTimeFormat("2006-01-02"), WithDurationFormat(DurationISO8601)},
+			"<rec price='12.50' ok='1'>\n  <at>2024-03-01</at>\n  <wait>PT1H30M0.5S</wait>\n</rec>"},
+		{"duration string", []WriterOption{WithDurationFormat(DurationString)},
+			"<rec price='12.5' ok='true'>\n  <at>2024-03-01T12:30:00Z</at>\n  <wait>1h30m0.5s</wait>\n</rec>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			p := NewStreamPrinter(&buf, Indent2Spaces, nil, WithErrorMode())
+			Marshal(NewWriter(p, tt.opts...), r)
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("got  %q\nwant %q", got, tt.want+"\n")
+			}
+		})
+	}
+}
+
+func TestValueFormatScalars(t *testing.T) {
+	buf := strings.Builder{}
+	p := NewStreamPrinter(&buf, IndentNone, nil, WithErrorMode())
+	w := NewWriter(p, WithFloatFormat('f', -1), WithIntBase(16), WithBoolFormat("yes", "no"))
+	w.Tag("x", Attr("f", 1e21), Attr("f32", float32(0.1)), Attr("i", -255), Attr("u", uint8(255)),
+		Attr("b", false), 1e-7, " ", true, " ", 4096)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "<x f='1000000000000000000000' f32='0.1' i='-ff' u='ff' b='no'>0.0000001 yes 1000</x>\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWithIntBase(t *testing.T) {
+	for _, base := range []int{-1, 0, 1, 37} {
+		func() {
+			defer func() {
+				if r := recover(); r != ErrInvalidIntBase {
+					t.Errorf("base %d: got %v, want %v", base, r, ErrInvalidIntBase)
+				}
+			}()
+			WithIntBase(base)
+		}()
+	}
+	buf := strings.Builder{}
+	p := NewStreamPrinter(&buf, IndentNone, nil, WithErrorMode())
+	NewWriter(p, WithIntBase(36)).Tag("x", Attr("k", 35), 71)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "<x k='z'>1z</x>\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "PT0S"},
+		{time.Nanosecond, "PT0.000000001S"},
+		{-90 * time.Second, "-PT1M30S"},
+		{49 * time.Hour, "PT49H"},
+		{time.Hour + 2*time.Second + 250*time.Millisecond, "PT1H2.25S"},
+	}
+	for _, tt := range tests {
+		if got := isoDuration(tt.d); got != tt.want {
+			t.Errorf("isoDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+type level int
+
+func (l level) MarshalText() ([]byte, error) {
+	return []byte("L" + strconv.Itoa(int(l))), nil
+}
+
+func TestValueFormatMarshalers(t *testing.T) {
+	for _, opts := range [][]WriterOption{nil, {WithFloatFormat('f', 2), WithIntBase(16)}} {
+		buf := strings.Builder{}
+		p := NewStreamPrinter(&buf, IndentNone, nil, WithErrorMode())
+		w := NewWriter(p, opts...)
+		w.Tag("x", Attr("l", level(3)), level(4))
+		if err := p.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := buf.String(), "<x l='L3'>L4</x>\n"; got != want {
+			t.Errorf("got  %q\nwant %q", got, want)
+		}
+	}
+}
+
+func TestValueFormatEscaping(t *testing.T) {
+	buf := strings.Builder{}
+	p := NewStreamPrinter(&buf, IndentNone, nil, WithErrorMode())
+	w := NewWriter(p, WithBoolFormat("a'<b", "n&"), WithTimeFormat("<2006>"))
+	w.Tag("x", Attr("b", true), Attr("t", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)), false)
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "<x b='a&apos;&lt;b' t='&lt;2024&gt;'>n&amp;</x>\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
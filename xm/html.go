@@ -0,0 +1,73 @@
+package xm
+
+import (
+	"errors"
+	"strings"
+)
+
+// HTMLDoctype is the <!DOCTYPE html> declaration that starts HTML5 documents.
+var HTMLDoctype = Doctype{Name: "html"}
+
+var (
+	ErrVoidContent = errors.New("xml writer: html void elements can not have content")
+	ErrRawTextEnd  = errors.New("xml: raw text contains the closing tag of its element")
+)
+
+// html_void lists HTML elements that have no content and no closing tag.
+var html_void = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true,
+	"hr": true, "img": true, "input": true, "link": true, "meta": true,
+	"source": true, "track": true, "wbr": true,
+}
+
+// html_raw_text lists HTML elements with content that is not escaped.
+var html_raw_text = map[string]bool{
+	"script": true, "style": true,
+}
+
+// html_inline lists HTML phrasing elements that are written inline.
+var html_inline = map[string]bool{
+	"a": true, "abbr": true, "b": true, "bdi": true, "bdo": true, "br": true,
+	"button": true, "cite": true, "code": true, "data": true, "del": true,
+	"dfn": true, "em": true, "i": true, "img": true, "input": true,
+	"ins": true, "kbd": true, "label": true, "mark": true, "q": true,
+	"s": true, "samp": true, "small": true, "span": true, "strong": true,
+	"sub": true, "sup": true, "time": true, "u": true, "var": true,
+	"wbr": true,
+}
+
+// html_pre lists HTML elements with significant whitespace.
+var html_pre = map[string]bool{
+	"pre": true, "textarea": true, "listing": true,
+}
+
+// HTMLTagger is the tagger that is used in HTML mode by default, it writes
+// phrasing elements like <a>, <em>, or <span> inline, <pre> and <textarea> as
+// Preformatted, <script> and <style> as RawText, and all other elements as
+// blocks.
+func HTMLTagger(name string) TagKind {
+	name = strings.ToLower(name)
+	switch {
+	case html_inline[name]:
+		return Inline
+	case html_pre[name]:
+		return Preformatted
+	case html_raw_text[name]:
+		return RawText
+	}
+	return Block
+}
+
+// containsCloseTag reports whether s contains "</name" in any letter case.
+func containsCloseTag(s, name string) bool {
+	for {
+		i := strings.Index(s, "</")
+		if i < 0 {
+			return false
+		}
+		s = s[i+2:]
+		if len(s) >= len(name) && strings.EqualFold(s[:len(name)], name) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package xm
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func ExampleWithHTML() {
+	Render(os.Stdout, func(w Writer) {
+		w.Cont(HTMLDoctype)
+		w.Tag("html", Attr("lang", "en"),
+			Tag("head",
+				Tag("meta", Attr("charset", "utf-8")),
+				Tag("script", "if (a < b && c) { go(); }"),
+			),
+			Tag("body",
+				Tag("p", "Fish\u00a0& Chips ", Tag("em", "now"), Tag("br"), "open"),
+				Tag("input", Attr("type", "checkbox"), Attr("checked", true), Attr("disabled", false)),
+				Tag("div"),
+			),
+		)
+	}, WithHTML())
+	// Output:
+	// <!DOCTYPE html>
+	// <html lang='en'>
+	//   <head>
+	//     <meta charset='utf-8'>
+	//     <script>if (a < b && c) { go(); }</script>
+	//   </head>
+	//   <body>
+	//     <p>Fish&nbsp;&amp; Chips <em>now</em><br>open</p>
+	//     <input type='checkbox' checked>
+	//     <div></div>
+	//   </body>
+	// </html>
+}
+
+func TestHTMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(Writer)
+		want error
+	}{
+		{"void content", func(w Writer) { w.Tag("br", "text") }, ErrVoidContent},
+		{"void child", func(w Writer) { w.Tag("img", Tag("b")) }, ErrVoidContent},
+		{"void comment", func(w Writer) { w.Tag("hr", Comment("c")) }, ErrVoidContent},
+		{"raw text end", func(w Writer) { w.Tag("script", "a = '</SCRIPT>'") }, ErrRawTextEnd},
+		{"raw text other", func(w Writer) { w.Tag("style", "</script>") }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			err := Render(&buf, tt.f, WithHTML())
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLScrambler(t *testing.T) {
+	sc := Scrambler{HTML: true, Quote: '"'}
+	attr, _ := sc.Attr("a\t\"b'<\u00a0")
+	cont, _ := sc.Cont("a\t\"b'<\u00a0\r")
+	if got, want := string(attr), "a\t&quot;b'&lt;&nbsp;"; got != want {
+		t.Errorf("Attr() = %q, want %q", got, want)
+	}
+	if got, want := string(cont), "a\t\"b'&lt;&nbsp;&#xD;"; got != want {
+		t.Errorf("Cont() = %q, want %q", got, want)
+	}
+	sc.Refs = RefDecimal
+	if cont, _ = sc.Cont("\u00a0"); string(cont) != "&#160;" {
+		t.Errorf("Cont() = %q, want %q", cont, "&#160;")
+	}
+}
@@ -0,0 +1,152 @@
+package xm
+
+import (
+	"errors"
+	"strings"
+)
+
+// Comment is written into content as <!--...-->. For indentation purposes,
+// comments are handled as empty block level tags.
+//
+// Comments, CDATA sections, processing instructions, and doctypes can not
+// contain character references, their characters are validated with
+// Scrambler.Verbatim.
+type Comment string
+
+// CData is written into content as <![CDATA[...]]>. Any ']]>' sequence within
+// the text is split across two adjacent CDATA sections. For indentation
+// purposes, CDATA sections are handled as empty inline tags.
+type CData string
+
+// PI is written into content as a processing instruction <?Target Inst?>. For
+// indentation purposes, processing instructions are handled as empty block
+// level tags.
+type PI struct {
+	Target string
+	Inst   string
+}
+
+// Decl is written as an XML declaration at the top of the document:
+//
+//	<?xml version='Version' encoding='Encoding' standalone='yes'?>
+//
+// Version defaults to "1.0", Encoding defaults to "UTF-8", the standalone
+// pseudo-attribute is only written if Standalone is true.
+type Decl struct {
+	Version    string
+	Encoding   string
+	Standalone bool
+}
+
+// Doctype is written as a document type declaration:
+//
+//	<!DOCTYPE Name PUBLIC "PublicID" "SystemID" [Subset]>
+//
+// PublicID and SystemID are optional, the internal Subset is optional and
+// written verbatim. Doctypes are only allowed in the document prolog.
+type Doctype struct {
+	Name     string
+	PublicID string
+	SystemID string
+	Subset   string
+}
+
+var (
+	ErrInvalidPI      = errors.New("xml: invalid processing instruction")
+	ErrInvalidDoctype = errors.New("xml: invalid doctype")
+)
+
+// MarshalXCont implements ContMarshaler, it fails with ErrInvalidComment if
+// the comment contains '--' or ends with '-'.
+func (c Comment) MarshalXCont(p Printer) {
+	if strings.Contains(string(c), "--") || strings.HasSuffix(string(c), "-") {
+		fail(p, ErrInvalidComment)
+		return
+	}
+	if s, ok := verbatimText(p, string(c)); ok {
+		markup(p, Block, RawCont("<!--"+s+"-->"))
+	}
+}
+
+// MarshalXCont implements ContMarshaler, see PrologPrinter.Decl.
+func (d Decl) MarshalXCont(p Printer) {
+	decl(p, d)
+}
+
+// MarshalXCont implements ContMarshaler.
+func (c CData) MarshalXCont(p Printer) {
+	s, ok := verbatimText(p, string(c))
+	if !ok {
+		return
+	}
+	s = strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>")
+	markup(p, Inline, RawCont("<![CDATA["+s+"]]>"))
+}
+
+// MarshalXCont implements ContMarshaler, it fails with ErrInvalidPI if the
+// target is empty or reserved, or if the instruction contains '?>'.
+func (pi PI) MarshalXCont(p Printer) {
+	if pi.Target == "" || strings.EqualFold(pi.Target, "xml") ||
+		strings.ContainsAny(pi.Target, " \t\r\n?>") ||
+		strings.Contains(pi.Inst, "?>") {
+		fail(p, ErrInvalidPI)
+		return
+	}
+	s := "<?" + pi.Target
+	if pi.Inst != "" {
+		s += " " + pi.Inst
+	}
+	s, ok := verbatimText(p, s)
+	if !ok {
+		return
+	}
+	if pi.Target == "xml-stylesheet" {
+		prolog(p, RawCont(s+"?>"))
+	} else {
+		markup(p, Block, RawCont(s+"?>"))
+	}
+}
+
+// MarshalXCont implements ContMarshaler, it fails with ErrInvalidDoctype if
+// the name is empty, if PublicID is specified without SystemID, or if the IDs
+// can not be quoted.
+func (d Doctype) MarshalXCont(p Printer) {
+	if d.Name == "" || strings.ContainsAny(d.Name, " \t\r\n[>") ||
+		(d.PublicID != "" && d.SystemID == "") ||
+		strings.Contains(d.PublicID, "\"") ||
+		strings.Contains(d.SystemID, "\"") && strings.Contains(d.SystemID, "'") ||
+		strings.Contains(d.Subset, "]>") {
+		fail(p, ErrInvalidDoctype)
+		return
+	}
+	s := "<!DOCTYPE " + d.Name
+	if d.PublicID != "" {
+		s += " PUBLIC \"" + d.PublicID + "\" " + quoteLiteral(d.SystemID)
+	} else if d.SystemID != "" {
+		s += " SYSTEM " + quoteLiteral(d.SystemID)
+	}
+	if d.Subset != "" {
+		s += " [" + d.Subset + "]"
+	}
+	if s, ok := verbatimText(p, s); ok {
+		prolog(p, RawCont(s+">"))
+	}
+}
+
+// Stylesheet creates an <?xml-stylesheet type='typ' href='href'?> processing
+// instruction, which is only allowed in the document prolog.
+func Stylesheet(typ, href string) PI {
+	return PI{
+		Target: "xml-stylesheet",
+		Inst:   "type='" + string(ScrambleAttr(typ)) + "' href='" + string(ScrambleAttr(href)) + "'",
+	}
+}
+
+// quoteLiteral wraps s in double quotes, or in single quotes if s contains
+// double quotes.
+func quoteLiteral(s string) string {
+	if strings.Contains(s, "\"") {
+		return "'" + s + "'"
+	}
+	return "\"" + s + "\""
+}
@@ -0,0 +1,126 @@
+package xm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func ExampleComment() {
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil))
+
+	w.Cont(Doctype{Name: "note", SystemID: "note.dtd"})
+	w.Tag("note",
+		Comment(" block level comment "),
+		PI{Target: "render", Inst: "mode='fast'"},
+		Tag("body", "text with ", CData("<raw> & ]]> data"), " inside"),
+	)
+
+	fmt.Println(buf.String())
+
+	// Output:
+	// <!DOCTYPE note SYSTEM "note.dtd">
+	// <note>
+	//   <!-- block level comment -->
+	//   <?render mode='fast'?>
+	//   <body>text with <![CDATA[<raw> & ]]]]><![CDATA[> data]]> inside</body>
+	// </note>
+}
+
+func TestMarkupValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		v    any
+		want error
+	}{
+		{"comment with --", Comment("a--b"), ErrInvalidComment},
+		{"comment ending with -", Comment("a-"), ErrInvalidComment},
+		{"pi with ?>", PI{Target: "t", Inst: "a?>b"}, ErrInvalidPI},
+		{"pi with reserved target", PI{Target: "XML"}, ErrInvalidPI},
+		{"pi without target", PI{}, ErrInvalidPI},
+		{"doctype without name", Doctype{}, ErrInvalidDoctype},
+		{"doctype public without system", Doctype{Name: "html", PublicID: "x"}, ErrInvalidDoctype},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrinter(IndentNone, func([]byte) {}, nil, WithErrorMode())
+			NewWriter(p).Cont(tt.v)
+			if err := p.Err(); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkupChars(t *testing.T) {
+	buf := strings.Builder{}
+	err := Render(&buf, func(w Writer) {
+		w.Tag("root", Comment(" caf\u00e9\x01 "), CData("\u00e9"), PI{Target: "t", Inst: "\u00e9"})
+	}, WithASCII())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "<root>\n  <!-- caf?? -->\n  <![CDATA[?]]>\n  <?t ??>\n</root>\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	err = Render(&buf, func(w Writer) {
+		w.Tag("root", Comment("a\x01"))
+	}, WithCharValidation(CharsXML10, InvalidReject))
+	var ce *InvalidCharError
+	if !errors.As(err, &ce) || ce.Offset != 1 || ce.Char != 1 {
+		t.Errorf("got %v, want InvalidCharError at offset 1", err)
+	}
+}
+
+func ExampleDecl() {
+	buf := strings.Builder{}
+	p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil)
+	w := NewWriter(p)
+
+	w.Cont(
+		Decl{Version: "1.1", Encoding: "ISO-8859-1", Standalone: true},
+		Comment(" Copyright (c) Example Authors "),
+		Stylesheet("text/xsl", "style.xsl"),
+		Doctype{Name: "html", PublicID: "-//W3C//DTD XHTML 1.0 Strict//EN",
+			SystemID: "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"},
+		Tag("html"),
+	)
+	p.Close()
+
+	fmt.Print(buf.String())
+
+	// Output:
+	// <?xml version='1.1' encoding='ISO-8859-1' standalone='yes'?>
+	// <!-- Copyright (c) Example Authors -->
+	// <?xml-stylesheet type='text/xsl' href='style.xsl'?>
+	// <!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+	// <html/>
+}
+
+func TestPrologPlacement(t *testing.T) {
+	tests := []struct {
+		name string
+		args []any
+		want error
+	}{
+		{"decl after comment", []any{Comment("c"), Decl{}}, ErrDeclPlacement},
+		{"invalid version", []any{Decl{Version: "2.0"}}, ErrInvalidDecl},
+		{"invalid encoding", []any{Decl{Encoding: "utf 8"}}, ErrInvalidDecl},
+		{"doctype in root", []any{Tag("root", Doctype{Name: "root"})}, ErrPrologPlacement},
+		{"doctype after root", []any{Tag("root"), Doctype{Name: "root"}}, ErrPrologPlacement},
+		{"stylesheet after root", []any{Tag("root"), Stylesheet("text/css", "a.css")}, ErrPrologPlacement},
+		{"comment after root", []any{Tag("root"), Comment("ok")}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrinter(IndentNone, func([]byte) {}, nil, WithErrorMode())
+			NewWriter(p).Cont(tt.args...)
+			if err := p.Err(); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -11,3 +11,56 @@ type ContMarshaler interface {
 }
 
 var ErrEmptyAttribute = errors.New("xml: empty sttribute")
+var ErrInvalidComment = errors.New("xml: comment must not contain '--' or end with '-'")
+var ErrInvalidName = errors.New("xml: map key is not a valid element name")
+
+// Marshal writes v into w. Structs are written as elements, the name of the
+// element is taken from the tag of the XMName field if present, otherwise the
+// name of the struct type is used. All other values are written with w.Cont().
+//
+// The fields of a struct are written according to their `xm` tags:
+//
+//	Field int `xm:"-"`                 // field is ignored
+//	Field int `xm:"name"`              // element <name>
+//	Field int `xm:"name,attr"`         // attribute name='...'
+//	Field int `xm:",attr"`             // attribute Field='...'
+//	Field int `xm:",chardata"`         // written as text content
+//	Field int `xm:",cdata"`            // string or []byte written as <![CDATA[...]]>
+//	Field int `xm:",innerxml"`         // string or []byte written verbatim
+//	Field int `xm:",comment"`          // string or []byte written as <!--...-->
+//	Field T   `xm:",inline"`           // fields of T are merged into the parent
+//	Field int `xm:"name,omitempty"`    // skipped if empty
+//	Field int `xm:"a>b>name"`          // element <name> nested in <a><b>
+//
+// Untagged fields are written as elements named after the field. Embedded
+// structs without an explicit name are inlined. Nil pointers are skipped,
+// slices and arrays produce one element per item, and nested structs are
+// written with their own attributes and content. Slices and arrays in
+// attribute fields are written as space separated lists. Fields that implement
+// Marshaler, ContMarshaler, AttrMarshaler, or encoding.TextMarshaler are
+// written through these interfaces.
+//
+// Structs can also be passed directly to Writer.Tag, in which case their
+// fields supply attributes and content to that tag.
+func Marshal(w Writer, v any) {
+	w.Cont(v)
+}
+
+// ListAttr is an attribute value that is written as a list of items joined
+// with separators. Items must be a slice or an array, its elements are
+// written like other attribute values. Sep[0] separates the items, the
+// subsequent separators are used for nested slices and arrays. Missing
+// separators default to a space.
+//
+// Slices and arrays that are passed as attribute values directly are written
+// as space separated lists.
+type ListAttr struct {
+	Items any
+	Sep   []string
+}
+
+// List creates a ListAttr, for example List(points, " ", ",") writes
+// [][2]float64 values in SVG notation: '0,0 10,5'.
+func List(items any, sep ...string) ListAttr {
+	return ListAttr{Items: items, Sep: sep}
+}
@@ -2,12 +2,19 @@ package xm
 
 import (
 	"encoding"
+	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 )
 
-func marshal_attr(val reflect.Value) (RawAttr, bool) {
-	// handle nil pointers
+func marshal_attr(w *writer_impl, val reflect.Value) (RawAttr, bool) {
+	p := w.p
+
+	// handle nils and nil pointers
+	if !val.IsValid() {
+		return nil, false
+	}
 	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
 		if val.IsNil() {
 			return nil, false
@@ -15,8 +22,30 @@ func marshal_attr(val reflect.Value) (RawAttr, bool) {
 		val = val.Elem()
 	}
 
-	// handle ContMarshaler values
+	// handle custom encoders
 	typ := val.Type()
+	if f := w.attrEncoder(typ); f != nil {
+		return f(val)
+	}
+	if s, ok := w.formatTime(val); ok {
+		return w.attrStr(s), true
+	}
+
+	// handle lists with custom separators
+	if typ == listAttrType {
+		l := val.Interface().(ListAttr)
+		items := indirect(reflect.ValueOf(l.Items))
+		if !items.IsValid() || isNil(items) {
+			return nil, false
+		}
+		if !isList(items) {
+			fail(p, &ErrUnsupportedType{items.Type()})
+			return nil, false
+		}
+		return marshal_list(w, items, l.Sep)
+	}
+
+	// handle AttrMarshaler values
 	if val.CanInterface() && typ.Implements(attrMarshalerType) {
 		v := val.Interface().(AttrMarshaler)
 		return v.MarshalXAttr()
@@ -31,25 +60,95 @@ func marshal_attr(val reflect.Value) (RawAttr, bool) {
 
 	// handle encoding.TextMarshaler values
 	if val.CanInterface() && typ.Implements(textMarshalerType) {
-		return textMarshalerToAttr(val.Interface().(encoding.TextMarshaler))
+		return textMarshalerToAttr(p, val.Interface().(encoding.TextMarshaler))
 	}
 	if val.CanAddr() {
 		pv := val.Addr()
 		if pv.CanInterface() && pv.Type().Implements(textMarshalerType) {
-			return textMarshalerToAttr(pv.Interface().(encoding.TextMarshaler))
+			return textMarshalerToAttr(p, pv.Interface().(encoding.TextMarshaler))
 		}
 	}
 
 	// handle booleans, integer, and floating point values
-	if s, ok := reflectCoreToStr(val); ok {
-		return RawAttr(s), true
+	if s, ok := w.reflectCoreToStr(val); ok {
+		return w.attrStr(s), true
+	}
+
+	// handle named string types
+	if val.Kind() == reflect.String {
+		r := scrambleAttr(p, val.String())
+		return r, len(r) > 0
+	}
+
+	// handle bytes as text, and other slices and arrays as space separated
+	// lists
+	if isBytes(val) {
+		r := scrambleAttr(p, string(val.Bytes()))
+		return r, len(r) > 0
+	}
+	if isList(val) {
+		return marshal_list(w, val, nil)
 	}
 
-	panic(&ErrUnsupportedType{val.Type()})
+	fail(p, &ErrUnsupportedType{val.Type()})
+	return nil, false
+}
+
+var listAttrType = reflect.TypeOf(ListAttr{})
+
+// isList reports whether val is a slice or an array that is written as a list
+// of items.
+func isList(val reflect.Value) bool {
+	return (val.Kind() == reflect.Slice || val.Kind() == reflect.Array) && !isBytes(val) &&
+		!implements(val, attrMarshalerType) && !implements(val, textMarshalerType)
 }
 
-func marshal_content(p Printer, val reflect.Value) {
-	// handle nil pointers
+// marshal_list writes items of val joined with seps[0], nested lists are
+// joined with the subsequent separators. The default separator is a space.
+func marshal_list(w *writer_impl, val reflect.Value, seps []string) (RawAttr, bool) {
+	p := w.p
+	sep := RawAttr(" ")
+	if len(seps) > 0 {
+		sep, seps = scrambleAttr(p, seps[0]), seps[1:]
+	}
+	var b RawAttr
+	written := false
+	n := val.Len()
+	for i := 0; i < n; i++ {
+		item := indirect(val.Index(i))
+		if !item.IsValid() {
+			continue
+		}
+		var r RawAttr
+		var ok bool
+		if isList(item) {
+			r, ok = marshal_list(w, item, seps)
+		} else {
+			r, ok = marshal_attr(w, item)
+		}
+		if printerErr(p) != nil {
+			return nil, false
+		}
+		// empty items are skipped along with their separators
+		if !ok {
+			continue
+		}
+		if written {
+			b = append(b, sep...)
+		}
+		b = append(b, r...)
+		written = true
+	}
+	return b, written
+}
+
+func marshal_content(w *writer_impl, val reflect.Value) {
+	p := w.p
+
+	// handle nils and nil pointers
+	if !val.IsValid() {
+		return
+	}
 	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
 		if val.IsNil() {
 			return
@@ -57,8 +156,18 @@ func marshal_content(p Printer, val reflect.Value) {
 		val = val.Elem()
 	}
 
-	// handle ContMarshaler values
+	// handle custom encoders
 	typ := val.Type()
+	if f := w.contEncoder(typ); f != nil {
+		f(p, val)
+		return
+	}
+	if s, ok := w.formatTime(val); ok {
+		p.Content(w.contStr(s))
+		return
+	}
+
+	// handle ContMarshaler values
 	if val.CanInterface() && typ.Implements(contMarshalerType) {
 		v := val.Interface().(ContMarshaler)
 		v.MarshalXCont(p)
@@ -73,6 +182,18 @@ func marshal_content(p Printer, val reflect.Value) {
 		}
 	}
 
+	// handle xml.Marshaler values in compatibility mode
+	if w.compat {
+		if m, ok := asXMLMarshaler(val); ok {
+			name, space := typ.Name(), ""
+			if val.Kind() == reflect.Struct {
+				name, space = getTypeInfo(typ, true).nameOf(val)
+			}
+			marshal_xml(w, space, name, m)
+			return
+		}
+	}
+
 	// handle encoding.TextMarshaler values
 	if val.CanInterface() && typ.Implements(textMarshalerType) {
 		textMarshalerToCont(p, val.Interface().(encoding.TextMarshaler))
@@ -87,36 +208,81 @@ func marshal_content(p Printer, val reflect.Value) {
 	}
 
 	// handle booleans, integer, and floating point values
-	if s, ok := reflectCoreToStr(val); ok {
-		p.Content(RawCont(s))
+	if s, ok := w.reflectCoreToStr(val); ok {
+		p.Content(w.contStr(s))
+		return
+	}
+
+	// handle named string types
+	if val.Kind() == reflect.String {
+		p.Content(scrambleCont(p, val.String()))
+		return
+	}
+
+	// handle sequences, bytes are written as text, other items are expanded
+	// as Cont arguments
+	if isBytes(val) {
+		p.Content(scrambleCont(p, string(val.Bytes())))
+		return
+	}
+	if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
+		n := val.Len()
+		for i := 0; i < n && printerErr(p) == nil; i++ {
+			w.Cont(contArg(val.Index(i)))
+		}
+		return
+	}
+
+	// handle maps, entries are written as <key>value</key> elements
+	if val.Kind() == reflect.Map {
+		for _, k := range mapKeys(val) {
+			name, ok := keyName(p, k)
+			if !ok || printerErr(p) != nil {
+				return
+			}
+			w.Tag(name, val.MapIndex(k).Interface())
+		}
+		return
+	}
+
+	// handle structs, these are written as elements
+	if val.Kind() == reflect.Struct {
+		name, space := getTypeInfo(typ, w.compat).nameOf(val)
+		if name == "" {
+			fail(p, &ErrUnsupportedType{typ})
+			return
+		}
+		marshal_tag(w, space, name, valueArg(val))
 		return
 	}
 
-	panic(&ErrUnsupportedType{val.Type()})
+	fail(p, &ErrUnsupportedType{val.Type()})
 }
 
 var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
 	attrMarshalerType = reflect.TypeOf((*AttrMarshaler)(nil)).Elem()
 	contMarshalerType = reflect.TypeOf((*ContMarshaler)(nil)).Elem()
 	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
 )
 
-func textMarshalerToAttr(v encoding.TextMarshaler) ([]byte, bool) {
+func textMarshalerToAttr(p Printer, v encoding.TextMarshaler) ([]byte, bool) {
 	b, e := v.MarshalText()
 	if e == nil {
-		r := ScrambleAttr(string(b))
+		r := scrambleAttr(p, string(b))
 		return r, len(b) > 0
 	} else {
-		panic(e)
+		fail(p, e)
+		return nil, false
 	}
 }
 
 func textMarshalerToCont(p Printer, v encoding.TextMarshaler) {
 	b, e := v.MarshalText()
 	if e == nil {
-		p.Content(ScrambleCont(string(b)))
+		p.Content(scrambleCont(p, string(b)))
 	} else {
-		panic(e)
+		fail(p, e)
 	}
 }
 
@@ -180,3 +346,309 @@ func reflectCoreToStr(val reflect.Value) (string, bool) {
 		return "", false
 	}
 }
+
+// structBody resolves arg into a struct value that supplies attributes and
+// content to the enclosing tag. Types that implement any of the marshaling
+// interfaces are not considered struct bodies.
+func (w *writer_impl) structBody(arg any) (reflect.Value, bool) {
+	switch arg.(type) {
+	case nil, RawCont, string, Marshaler, ContMarshaler, encoding.TextMarshaler,
+		func(ContWriter), func(TagWriter), func(Writer), func(Printer),
+		map[string]any, AttrList, func(AttrWriter), TagOption:
+		return reflect.Value{}, false
+	}
+	val := reflect.ValueOf(arg)
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return reflect.Value{}, false
+		}
+		val = val.Elem()
+	}
+	if val.Kind() != reflect.Struct || hasMarshaler(val) || w.contEncoder(val.Type()) != nil {
+		return reflect.Value{}, false
+	}
+	if w.compat {
+		if _, ok := asXMLMarshaler(val); ok {
+			return reflect.Value{}, false
+		}
+	}
+	return val, true
+}
+
+// hasMarshaler reports whether val, or a pointer to val, implements one of the
+// interfaces that customize content marshaling.
+func hasMarshaler(val reflect.Value) bool {
+	return implements(val, marshalerType) || implements(val, contMarshalerType) ||
+		implements(val, textMarshalerType)
+}
+
+// implements reports whether val, or a pointer to val, implements iface.
+func implements(val reflect.Value, iface reflect.Type) bool {
+	return val.Type().Implements(iface) ||
+		(val.CanAddr() && reflect.PointerTo(val.Type()).Implements(iface))
+}
+
+// valueArg converts val into an interface value, addressable values are
+// converted to pointers so that methods with pointer receivers are reachable.
+func valueArg(val reflect.Value) any {
+	if val.CanAddr() && val.Kind() != reflect.Ptr && val.Kind() != reflect.Interface {
+		return val.Addr().Interface()
+	}
+	return val.Interface()
+}
+
+// contArg converts a sequence item into a Cont argument. Addressable items are
+// converted to pointers only if the pointer type has additional methods, so
+// that items like RawCont or func(Writer) keep their types.
+func contArg(val reflect.Value) any {
+	if val.CanAddr() && val.Kind() != reflect.Ptr && val.Kind() != reflect.Interface &&
+		reflect.PointerTo(val.Type()).NumMethod() > val.Type().NumMethod() {
+		return val.Addr().Interface()
+	}
+	return val.Interface()
+}
+
+// mapKeys returns the keys of the map val in sorted order. Numeric keys are
+// sorted by value, other keys are sorted by their string representation.
+func mapKeys(val reflect.Value) []reflect.Value {
+	keys := val.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		default:
+			return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+		}
+	})
+	return keys
+}
+
+// keyName converts a map key into an element name, it fails with
+// ErrInvalidName if the key does not match the XML Name production.
+func keyName(p Printer, key reflect.Value) (string, bool) {
+	name, ok := "", false
+	if key.CanInterface() {
+		if m, is := key.Interface().(encoding.TextMarshaler); is {
+			b, err := m.MarshalText()
+			if err != nil {
+				fail(p, err)
+				return "", false
+			}
+			name, ok = string(b), true
+		}
+	}
+	if !ok && key.Kind() == reflect.String {
+		name, ok = key.String(), true
+	}
+	if !ok {
+		name, ok = reflectCoreToStr(key)
+	}
+	if !ok {
+		fail(p, &ErrUnsupportedType{key.Type()})
+		return "", false
+	}
+	if !isName(name) {
+		fail(p, ErrInvalidName)
+		return "", false
+	}
+	return name, true
+}
+
+// isName reports whether s matches the Name production of XML 1.0.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		if !isNameStartChar(r) && (i == 0 || !isNameChar(r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameStartChar(r rune) bool {
+	return r == ':' || r == '_' ||
+		'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z' ||
+		0xC0 <= r && r <= 0xD6 || 0xD8 <= r && r <= 0xF6 ||
+		0xF8 <= r && r <= 0x2FF || 0x370 <= r && r <= 0x37D ||
+		0x37F <= r && r <= 0x1FFF || 0x200C <= r && r <= 0x200D ||
+		0x2070 <= r && r <= 0x218F || 0x2C00 <= r && r <= 0x2FEF ||
+		0x3001 <= r && r <= 0xD7FF || 0xF900 <= r && r <= 0xFDCF ||
+		0xFDF0 <= r && r <= 0xFFFD || 0x10000 <= r && r <= 0xEFFFF
+}
+
+func isNameChar(r rune) bool {
+	return r == '-' || r == '.' || '0' <= r && r <= '9' || r == 0xB7 ||
+		0x300 <= r && r <= 0x36F || 0x203F <= r && r <= 0x2040
+}
+
+// marshal_struct_attrs writes fields tagged with the 'attr' option as
+// attributes.
+func marshal_struct_attrs(w *writer_impl, val reflect.Value) {
+	ti := getTypeInfo(val.Type(), w.compat)
+	for i := range ti.fields {
+		f := &ti.fields[i]
+		if f.flags&fAttr == 0 {
+			continue
+		}
+		fv, ok := fieldValue(val, f.idx)
+		if !ok || isNil(fv) {
+			continue
+		}
+		omit := f.flags&fOmitEmpty != 0
+		if omit && isEmptyValue(fv) {
+			continue
+		}
+		w.attrEx(f.space, f.name, valueArg(fv), omit)
+	}
+}
+
+// marshal_struct_cont writes all non-attribute fields as content.
+func marshal_struct_cont(w *writer_impl, val reflect.Value) {
+	ti := getTypeInfo(val.Type(), w.compat)
+	var parents []string // currently open elements from 'a>b>c' paths
+	for i := range ti.fields {
+		f := &ti.fields[i]
+		if f.flags&fAttr != 0 {
+			continue
+		}
+		fv, ok := fieldValue(val, f.idx)
+
+		// close parents that are not shared with this field
+		n := 0
+		for n < len(parents) && n < len(f.parents) && parents[n] == f.parents[n] {
+			n++
+		}
+		for len(parents) > n {
+			w.p.CTag()
+			parents = parents[:len(parents)-1]
+		}
+		if !ok || isNil(fv) {
+			continue
+		}
+		for _, name := range f.parents[n:] {
+			w.p.OTag(name)
+			parents = append(parents, name)
+		}
+
+		if f.flags&(fElement|fAny) == 0 {
+			fv = indirect(fv)
+		}
+		switch f.flags & fMode {
+		case fElement, fAny:
+			marshal_element(w, f.space, f.name, fv, f.flags&fOmitEmpty != 0)
+		case fCharData:
+			if isBytes(fv) && !hasMarshaler(fv) {
+				w.Cont(string(fv.Bytes()))
+			} else {
+				w.Cont(valueArg(fv))
+			}
+		case fCDATA:
+			if s, ok := rawText(w.p, fv); ok {
+				CData(s).MarshalXCont(w.p)
+			}
+		case fInnerXML:
+			if s, ok := rawText(w.p, fv); ok {
+				w.p.Content(RawCont(s))
+			}
+		case fComment:
+			if s, ok := rawText(w.p, fv); ok {
+				Comment(s).MarshalXCont(w.p)
+			}
+		}
+	}
+	for range parents {
+		w.p.CTag()
+	}
+}
+
+// marshal_element writes val as one or more elements with the specified name,
+// slices and arrays produce one element per item. If name is empty, the
+// element name is derived from the value.
+func marshal_element(w *writer_impl, space, name string, val reflect.Value, omitempty bool) {
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return
+		}
+		val = val.Elem()
+	}
+	if omitempty && isEmptyValue(val) {
+		return
+	}
+	if w.compat {
+		if val.Kind() == reflect.Struct {
+			name, space = getTypeInfo(val.Type(), true).elementName(val, name, space)
+		}
+		if m, ok := asXMLMarshaler(val); ok {
+			if name == "" {
+				name = val.Type().Name()
+			}
+			marshal_xml(w, space, name, m)
+			return
+		}
+	}
+	switch {
+	case isBytes(val) && !hasMarshaler(val):
+		w.Tag(name, string(val.Bytes()))
+	case (val.Kind() == reflect.Slice || val.Kind() == reflect.Array) && !hasMarshaler(val):
+		n := val.Len()
+		for i := 0; i < n; i++ {
+			marshal_element(w, space, name, val.Index(i), false)
+		}
+	case name == "":
+		marshal_content(w, val)
+	default:
+		marshal_tag(w, space, name, valueArg(val))
+	}
+}
+
+// marshal_tag writes an element in the namespace space. In compatibility
+// mode, namespaces are bound to the default namespace like in encoding/xml,
+// the declarations are only written where the namespace is not in scope yet.
+func marshal_tag(w *writer_impl, space, name string, args ...any) {
+	if space == "" {
+		w.Tag(name, args...)
+		return
+	}
+	if w.compat {
+		preferPrefix(w.p, space, "")
+	}
+	w.NSTag(space, name, args...)
+}
+
+// indirect dereferences pointers and interfaces, nil values are returned as-is.
+func indirect(val reflect.Value) reflect.Value {
+	for (val.Kind() == reflect.Interface || val.Kind() == reflect.Ptr) && !val.IsNil() {
+		val = val.Elem()
+	}
+	return val
+}
+
+func isNil(val reflect.Value) bool {
+	return (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) && val.IsNil()
+}
+
+func isBytes(val reflect.Value) bool {
+	return val.Kind() == reflect.Slice && val.Type().Elem().Kind() == reflect.Uint8
+}
+
+// rawText extracts text from string and []byte values.
+func rawText(p Printer, val reflect.Value) (string, bool) {
+	switch {
+	case val.Kind() == reflect.String:
+		return val.String(), true
+	case isBytes(val):
+		return string(val.Bytes()), true
+	default:
+		fail(p, &ErrUnsupportedType{val.Type()})
+		return "", false
+	}
+}
@@ -0,0 +1,304 @@
+package xm
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type Address struct {
+	City   string `xm:"city"`
+	Street string `xm:"street,omitempty"`
+}
+
+type Meta struct {
+	Created string `xm:"created,attr"`
+}
+
+type Person struct {
+	XMName struct{} `xm:"person"`
+	Meta
+	ID      int      `xm:"id,attr"`
+	Nick    string   `xm:"nick,attr,omitempty"`
+	Note    string   `xm:",comment"`
+	Name    string   `xm:"name"`
+	Emails  []string `xm:"email"`
+	Home    *Address `xm:"home"`
+	Work    *Address `xm:"work"`
+	Skipped string   `xm:"-"`
+	Extra   UserType `xm:"extra"`
+	private int
+}
+
+func ExampleMarshal() {
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil))
+
+	Marshal(w, &Person{
+		Meta:   Meta{Created: "today"},
+		ID:     7,
+		Note:   " generated ",
+		Name:   "John <Doe>",
+		Emails: []string{"john@example.com", "doe@example.com"},
+		Home:   &Address{City: "Springfield"},
+	})
+
+	fmt.Println(buf.String())
+
+	// Output:
+	// <person created='today' id='7'>
+	//   <!-- generated -->
+	//   <name>John &lt;Doe&gt;</name>
+	//   <email>john@example.com</email>
+	//   <email>doe@example.com</email>
+	//   <home>
+	//     <city>Springfield</city>
+	//   </home>
+	//   <extra>
+	//     <usertype k='v'>content</usertype>
+	//   </extra>
+	// </person>
+}
+
+func TestMarshalTagStruct(t *testing.T) {
+	type Text struct {
+		Lang string `xm:"lang,attr"`
+		Body string `xm:",chardata"`
+		Raw  []byte `xm:",innerxml"`
+	}
+
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil))
+	w.Tag("text", Attr("id", 1), Text{Lang: "en", Body: "a&b", Raw: []byte("<br/>")})
+
+	want := "<text id='1' lang='en'>a&amp;b<br/></text>"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+type celsius float64
+
+func (c celsius) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: fmt.Sprintf("%.1fC", float64(c))}, nil
+}
+
+type legacy struct{}
+
+func (legacy) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement("legacy", start)
+}
+
+func TestMarshalXMLCompat(t *testing.T) {
+	type Station struct {
+		XMLName xml.Name `xml:"urn:wx station"`
+		ID      string   `xml:"id,attr"`
+		Temp    celsius  `xml:"temp,attr"`
+		Lat     float64  `xml:"pos>lat"`
+		Lon     float64  `xml:"pos>lon"`
+		Alt     int      `xml:"alt,omitempty"`
+		Old     legacy   `xml:"old"`
+		Notes   string   `xml:",cdata"`
+	}
+
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil), WithXMLCompat())
+	Marshal(w, Station{ID: "x1", Temp: 21.5, Lat: 1.5, Lon: -2, Old: legacy{}, Notes: "a]]>b"})
+
+	want := "<station xmlns='urn:wx' id='x1' temp='21.5C'><pos><lat>1.5</lat><lon>-2</lon></pos>" +
+		"<old>legacy</old><![CDATA[a]]]]><![CDATA[>b]]></station>"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestMarshalXMLCompatNamespaces(t *testing.T) {
+	type Inner struct {
+		V string `xml:",chardata"`
+	}
+	type Outer struct {
+		XMLName xml.Name `xml:"urn:a outer"`
+		In      Inner    `xml:"urn:a inner"`
+		Other   Inner    `xml:"urn:b other"`
+		Plain   Inner    `xml:"plain"`
+	}
+
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil), WithXMLCompat())
+	Marshal(w, Outer{In: Inner{"1"}, Other: Inner{"2"}, Plain: Inner{"3"}})
+
+	want := "<outer xmlns='urn:a'><inner>1</inner><other xmlns='urn:b'>2</other><plain>3</plain></outer>"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+type spaced struct{}
+
+func (spaced) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return e.EncodeElement(start.Name.Space, start)
+}
+
+type spacedAttr string
+
+func (a spacedAttr) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: xml.Name{Space: "urn:q", Local: name.Local}, Value: name.Space + string(a)}, nil
+}
+
+// TestMarshalXMLCompatOutput compares the output in compatibility mode with
+// xml.Marshal for the same values. Prefixes of namespaced attributes differ,
+// so these are compared by resolved names.
+func TestMarshalXMLCompatOutput(t *testing.T) {
+	type Item struct {
+		V string `xml:",chardata"`
+	}
+	type Named struct {
+		XMLName xml.Name `xml:"urn:n named"`
+		V       string   `xml:",chardata"`
+	}
+	type Dynamic struct {
+		XMLName xml.Name
+		V       string `xml:",chardata"`
+	}
+	type Wildcards struct {
+		XMLName xml.Name `xml:"w"`
+		Any     Item     `xml:",any"`
+		Named   Named    `xml:",any"`
+		Str     string   `xml:",any"`
+	}
+	type Names struct {
+		XMLName xml.Name `xml:"names"`
+		Name    xml.Name
+		Dyn     Dynamic
+		Tagged  Dynamic `xml:"tagged"`
+	}
+	type Marshalers struct {
+		XMLName xml.Name   `xml:"urn:b m"`
+		Spaced  spaced     `xml:"urn:s spaced"`
+		Attr    spacedAttr `xml:"urn:r a,attr"`
+		Plain   spacedAttr `xml:"p,attr"`
+		Empty   string     `xml:"urn:z e,attr,omitempty"`
+		Full    string     `xml:"urn:z f,attr"`
+	}
+
+	tests := []struct {
+		name string
+		v    any
+	}{
+		{"any", Wildcards{Any: Item{"1"}, Named: Named{V: "2"}, Str: "3"}},
+		{"names", Names{Name: xml.Name{Space: "s", Local: "l"},
+			Dyn: Dynamic{XMLName: xml.Name{Space: "urn:d", Local: "dyn"}, V: "1"}, Tagged: Dynamic{V: "2"}}},
+		{"marshalers", Marshalers{Attr: "1", Plain: "2", Full: "3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, err := xml.Marshal(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf := strings.Builder{}
+			p := NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil,
+				WithQuote('"'), WithSelfClose(SelfCloseNever), WithErrorMode())
+			Marshal(NewWriter(p, WithXMLCompat()), tt.v)
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			got := strings.TrimSuffix(buf.String(), "\n")
+			if tokens(t, got) != tokens(t, string(want)) {
+				t.Errorf("got  %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+// tokens returns the tokens of the XML document s with resolved names,
+// namespace declarations are skipped.
+func tokens(t *testing.T, s string) string {
+	b := strings.Builder{}
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return b.String()
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if e, ok := tok.(xml.StartElement); ok {
+			attrs := e.Attr[:0]
+			for _, a := range e.Attr {
+				if a.Name.Space != "xmlns" && (a.Name.Space != "" || a.Name.Local != "xmlns") {
+					attrs = append(attrs, a)
+				}
+			}
+			tok = xml.StartElement{Name: e.Name, Attr: attrs}
+		}
+		fmt.Fprintf(&b, "%#v\n", tok)
+	}
+}
+
+func TestMarshalXMLCompatOptAttr(t *testing.T) {
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil), WithXMLCompat())
+	w.Tag("x", func(a AttrWriter) {
+		a.OptAttr("a", celsiusNone{})
+		a.Attr("b", celsiusNone{})
+	})
+
+	want := "<x b=''/>"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+type celsiusNone struct{}
+
+func (celsiusNone) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name}, nil
+}
+
+func TestListAttr(t *testing.T) {
+	type shape struct {
+		XMName struct{}   `xm:"shape"`
+		Class  []string   `xm:"class,attr,omitempty"`
+		Box    [4]float64 `xm:"viewBox,attr"`
+	}
+	tests := []struct {
+		name string
+		arg  any
+		want string
+	}{
+		{"strings", Attr("class", []string{"a", "b&c"}), "<x class='a b&amp;c'/>"},
+		{"array", Attr("viewBox", [4]int{0, 0, 10, 20}), "<x viewBox='0 0 10 20'/>"},
+		{"custom sep", Attr("v", List([]float64{1.5, 2}, ",")), "<x v='1.5,2'/>"},
+		{"nested", Attr("points", List([][2]int{{0, 0}, {10, 5}}, " ", ",")), "<x points='0,0 10,5'/>"},
+		{"nested default", Attr("points", [][]int{{1, 2}, {3}}), "<x points='1 2 3'/>"},
+		{"text marshalers", Attr("ips", []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}),
+			"<x ips='10.0.0.1 10.0.0.2'/>"},
+		{"bytes", Attr("b", []byte("x<y")), "<x b='x&lt;y'/>"},
+		{"empty", func(w AttrWriter) { w.OptAttr("class", []string{}) }, "<x/>"},
+		{"empty items", Attr("class", []any{"", "a", nil, "", "b", ""}), "<x class='a b'/>"},
+		{"only empty items", func(w AttrWriter) { w.OptAttr("class", []string{"", ""}) }, "<x/>"},
+		{"struct", shape{Class: []string{"a", "b"}, Box: [4]float64{0, 0, 1.5, 2}},
+			"<x class='a b' viewBox='0 0 1.5 2'/>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			if err := Render(&buf, func(w Writer) { w.Tag("x", tt.arg) }); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("got  %q\nwant %q", got, tt.want+"\n")
+			}
+		})
+	}
+
+	err := Render(io.Discard, func(w Writer) { w.Tag("x", Attr("v", List(42))) })
+	if _, ok := errors.Unwrap(err).(*ErrUnsupportedType); !ok {
+		t.Errorf("got %v, want ErrUnsupportedType", err)
+	}
+}
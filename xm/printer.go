@@ -1,5 +1,12 @@
 package xm
 
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
 // RawAttr is used when writing XML attribute values to indicate that a value
 // can be written without any further processing (bypasses ScrambleAttr)
 type RawAttr []byte
@@ -13,7 +20,8 @@ type DeclPrinter interface {
 	// BOM writes UTF-8 byte order mask.
 	BOM()
 
-	// XmlDecl writes XmlDecl at the top of the file.
+	// XmlDecl writes XmlDecl at the top of the file. It fails with
+	// ErrDeclPlacement if anything besides BOM was already written.
 	XmlDecl()
 }
 
@@ -21,7 +29,9 @@ type DeclPrinter interface {
 type AttrPrinter interface {
 	// Attr adds key='val' pairs to a previously opened tag. Notice that Attr works
 	// only immediately after opening the tag, once the opening tag is finalized,
-	// calling Attr will panic. See description of OTag() for more details.
+	// calling Attr fails with ErrAttrPlacement. See description of OTag() for
+	// more details. The printer keeps a copy of val, so the caller may reuse it
+	// once Attr returns.
 	Attr(key string, val RawAttr)
 }
 
@@ -95,6 +105,11 @@ type TagPrinter interface {
 
 // Printer combines DeclPrinter, AttrPrinter, ContPrinter, and TagPrinter
 // interfaces into one providing the complete support for XML syntax.
+//
+// The printers of this package also implement the optional PrologPrinter,
+// MarkupPrinter, OptTagPrinter, StackPrinter, NSPrinter, and ErrPrinter
+// interfaces, see FullPrinter. The package falls back to the basic Printer
+// methods where the optional interfaces are not implemented.
 type Printer interface {
 	DeclPrinter
 	AttrPrinter
@@ -102,14 +117,273 @@ type Printer interface {
 	TagPrinter
 }
 
+// PrologPrinter is an optional interface for writing the document prolog.
+type PrologPrinter interface {
+	// Decl works like XmlDecl, but it allows to customize the version,
+	// encoding, and standalone pseudo-attributes of the declaration. It fails
+	// with ErrInvalidDecl if the version or the encoding are not valid.
+	Decl(d Decl)
+
+	// Prolog writes markup that is only allowed in the document prolog, like
+	// doctype declarations and stylesheet processing instructions. It fails
+	// with ErrPrologPlacement if called within a tag or after the root tag.
+	// For indentation purposes, the markup is handled like an empty block
+	// level tag.
+	Prolog(raw RawCont)
+}
+
+// MarkupPrinter is an optional interface for writing markup constructs.
+type MarkupPrinter interface {
+	// Markup places a self-contained markup construct, like a comment or a
+	// processing instruction, into the output. For indentation purposes, it is
+	// handled as an empty tag of kind k. The raw bytes are written verbatim.
+	Markup(k TagKind, raw RawCont)
+}
+
+// OptTagPrinter is an optional interface for writing tags that are omitted
+// if they stay empty.
+type OptTagPrinter interface {
+	// OptOTag works like OTag, but writing the tag is deferred until it gets
+	// content, child tags, or markup. If the tag is closed with CTag before
+	// that, it is omitted entirely, unless it has attributes and omitAttrs
+	// is false. Empty content does not open deferred tags.
+	OptOTag(name string, omitAttrs bool)
+}
+
+// StackPrinter is an optional interface for inspecting the open tags.
+type StackPrinter interface {
+	// OpenTags returns the names of currently open tags, starting with the
+	// outermost one. The returned slice must not be modified, and it is only
+	// valid until the next OTag or CTag call.
+	OpenTags() []string
+}
+
+// XMLNamespace is the namespace that is implicitly bound to the 'xml' prefix.
+const XMLNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// NSPrinter is an optional interface for writing namespace-qualified tags and
+// attributes. The printer keeps track of namespace bindings declared by the
+// open tags. Prefixes are declared with xmlns attributes on the tags where they
+// are first needed, bindings inherited from parent tags are reused.
+type NSPrinter interface {
+	// NSOTag works like OTag, but it qualifies the local name with a prefix
+	// bound to the namespace uri. If uri is empty, the tag is written without
+	// a namespace, resetting the default namespace if necessary.
+	NSOTag(uri, local string)
+
+	// NSOptOTag works like NSOTag, but writing the tag is deferred like with
+	// OptOTag. Namespace declarations do not count as attributes when the
+	// tag is omitted.
+	NSOptOTag(uri, local string, omitAttrs bool)
+
+	// NSAttr works like Attr, but it qualifies the local name with a prefix
+	// bound to the namespace uri. Notice that the default namespace does not
+	// apply to attributes, so attributes always get a non-empty prefix.
+	NSAttr(uri, local string, val RawAttr)
+
+	// PreferPrefix pins the prefix that is used when uri is declared. An empty
+	// prefix makes uri the default namespace for tags. If the preferred
+	// prefix is already bound to another namespace, a generated prefix is used
+	// instead.
+	PreferPrefix(uri, prefix string)
+}
+
+// ErrPrinter is an optional interface for reporting errors that occur while
+// printing.
+//
+// By default, the printer panics with a *TagError when it encounters an error.
+// Printers created with the WithErrorMode option record the first error
+// instead, and all subsequent printing calls are ignored. The error is also
+// recorded before panicking, a printer that recovered from the panic stays
+// failed: it ignores all subsequent printing calls, and Err and Close return
+// the error.
+type ErrPrinter interface {
+	// Err returns the first error recorded by the printer, or nil.
+	Err() error
+
+	// Fail records err as the printer error, unless another error was
+	// recorded before. The error is wrapped into a *TagError holding the
+	// path of the currently open tags. In panic mode, Fail panics with the
+	// recorded error.
+	Fail(err error)
+}
+
+// FullPrinter is a Printer that implements all the optional printer
+// interfaces, it is returned by NewPrinter.
+type FullPrinter interface {
+	Printer
+	PrologPrinter
+	MarkupPrinter
+	OptTagPrinter
+	StackPrinter
+	NSPrinter
+	ErrPrinter
+
+	// Close finalizes the document. It verifies that all tags are closed,
+	// writes the final newline, and flushes buffered output. Open tags are
+	// closed automatically if the printer was created with the WithAutoClose
+	// option, otherwise Close returns ErrUnclosedTag. With the
+	// WithStrictDocument option, Close returns ErrNoRoot if no root tag was
+	// written. Close returns the first error recorded by the printer, it does
+	// not panic. All printing calls after Close are ignored.
+	Close() error
+
+	// Scrambler returns the scrambler that matches the printer configuration.
+	// Use it to convert text into RawAttr and RawCont values.
+	Scrambler() Scrambler
+}
+
+// scramblerPrinter is implemented by printers that configure scrambling.
+type scramblerPrinter interface {
+	Scrambler() Scrambler
+}
+
+// scramblerOf returns the scrambler of p, or the zero Scrambler.
+func scramblerOf(p Printer) Scrambler {
+	if sp, ok := p.(scramblerPrinter); ok {
+		return sp.Scrambler()
+	}
+	return Scrambler{}
+}
+
+// printerErr returns the error recorded by p, printers that do not implement
+// ErrPrinter never record errors.
+func printerErr(p Printer) error {
+	if ep, ok := p.(ErrPrinter); ok {
+		return ep.Err()
+	}
+	return nil
+}
+
+// fail reports err with p.Fail(), printers that do not implement ErrPrinter
+// panic with err.
+func fail(p Printer, err error) {
+	if ep, ok := p.(ErrPrinter); ok {
+		ep.Fail(err)
+		return
+	}
+	panic(err)
+}
+
+// markup writes raw with p.Markup(), or as content.
+func markup(p Printer, k TagKind, raw RawCont) {
+	if mp, ok := p.(MarkupPrinter); ok {
+		mp.Markup(k, raw)
+		return
+	}
+	p.Content(raw)
+}
+
+// prolog writes raw with p.Prolog(), or as content.
+func prolog(p Printer, raw RawCont) {
+	if pp, ok := p.(PrologPrinter); ok {
+		pp.Prolog(raw)
+		return
+	}
+	p.Content(raw)
+}
+
+// decl writes d with p.Decl(), printers that do not implement PrologPrinter
+// only support the default declaration.
+func decl(p Printer, d Decl) {
+	if pp, ok := p.(PrologPrinter); ok {
+		pp.Decl(d)
+	} else if d == (Decl{}) {
+		p.XmlDecl()
+	} else {
+		fail(p, ErrNotSupported)
+	}
+}
+
+// optOTag opens a tag with p.OptOTag(), or with p.OTag().
+func optOTag(p Printer, name string, omitAttrs bool) {
+	if op, ok := p.(OptTagPrinter); ok {
+		op.OptOTag(name, omitAttrs)
+		return
+	}
+	p.OTag(name)
+}
+
+// nsOTag opens a tag with p.NSOTag() or p.NSOptOTag(), printers that do not
+// implement NSPrinter only support tags without a namespace.
+func nsOTag(p Printer, uri, local string, opt, omitAttrs bool) {
+	np, ok := p.(NSPrinter)
+	switch {
+	case ok && opt:
+		np.NSOptOTag(uri, local, omitAttrs)
+	case ok:
+		np.NSOTag(uri, local)
+	case uri != "":
+		fail(p, ErrNotSupported)
+	case opt:
+		optOTag(p, local, omitAttrs)
+	default:
+		p.OTag(local)
+	}
+}
+
+// nsAttr writes an attribute with p.NSAttr(), printers that do not implement
+// NSPrinter only support attributes without a namespace.
+func nsAttr(p Printer, uri, local string, val RawAttr) {
+	if np, ok := p.(NSPrinter); ok {
+		np.NSAttr(uri, local, val)
+	} else if uri == "" {
+		p.Attr(local, val)
+	} else {
+		fail(p, ErrNotSupported)
+	}
+}
+
+// preferPrefix calls p.PreferPrefix() if p implements NSPrinter.
+func preferPrefix(p Printer, uri, prefix string) {
+	if np, ok := p.(NSPrinter); ok {
+		np.PreferPrefix(uri, prefix)
+	}
+}
+
+var (
+	ErrDeclPlacement = errors.New("xml writer: invalid XmlDecl placement")
+	ErrAttrPlacement = errors.New("xml writer: invalid xml printer.Attr call")
+	ErrEmptyTagName  = errors.New("xml writer: trying to write a tag with empty name")
+	ErrUnpairedCTag  = errors.New("xml writer: tag stack underflow, unpaired CTag call")
+	ErrUnclosedTag   = errors.New("xml writer: document closed with unclosed tags")
+	ErrInvalidDecl   = errors.New("xml writer: invalid xml declaration")
+
+	ErrPrologPlacement = errors.New("xml writer: markup is only allowed in the document prolog")
+
+	ErrMultipleRoots      = errors.New("xml writer: document must have exactly one root tag")
+	ErrNoRoot             = errors.New("xml writer: document has no root tag")
+	ErrContentOutsideRoot = errors.New("xml writer: content outside of the root tag")
+	ErrDuplicateAttr      = errors.New("xml writer: duplicate attribute")
+	ErrEndOrder           = errors.New("xml writer: element closed out of order")
+	ErrNotSupported       = errors.New("xml writer: operation is not supported by the printer or writer")
+)
+
+// TagError records an error along with the path of tags that were open when
+// the error occurred.
+type TagError struct {
+	Path []string
+	Err  error
+}
+
+func (e *TagError) Error() string {
+	return e.Err.Error() + " (at /" + strings.Join(e.Path, "/") + ")"
+}
+
+func (e *TagError) Unwrap() error {
+	return e.Err
+}
+
 // TagKind is used to customize the behavior of tags when styling the XML
 // document's appearance with automatic indentation.
 type TagKind int
 
 const (
 	// Accepted values for TagKind:
-	Block  = TagKind(iota) // block level indentation (default)
-	Inline                 // inline tag
+	Block        = TagKind(iota) // block level indentation (default)
+	Inline                       // inline tag
+	Preformatted                 // block level tag with significant whitespace, its content and descendants are not indented
+	RawText                      // block level tag with content that is not re-indented, in HTML mode the content is also not escaped
 )
 
 // IndentStyle configures indentation in the XML document.
@@ -123,21 +397,97 @@ const (
 	IndentNone    = IndentStyle(-1) // no new lines, no indentation
 )
 
+// PrinterFlags configure optional printer behavior.
 type PrinterFlags uint
 
 const (
-	PreserveInlineWhitespace = PrinterFlags(1 << iota)
+	// Accepted values for PrinterFlags:
+	PreserveInlineWhitespace = PrinterFlags(1 << iota) // do not re-indent content after linebreaks
 )
 
-// NewPrinter creates a new Printer for writing XML files.
+// SelfCloseStyle configures how empty tags are written.
+type SelfCloseStyle int
+
+const (
+	// Accepted values for SelfCloseStyle:
+	SelfCloseCompact = SelfCloseStyle(iota) // <x/> (default)
+	SelfCloseSpaced                         // <x />
+	SelfCloseNever                          // <x></x>
+)
+
+// AttrAlign selects the placement of attributes that are wrapped onto
+// separate lines.
+type AttrAlign int
+
+const (
+	// Accepted values for AttrAlign:
+	AttrAlignFirst  = AttrAlign(iota) // aligned under the first attribute (default)
+	AttrAlignIndent                   // indented one level deeper than the line with the tag
+)
+
+// AttrOrder selects the order in which attributes of a tag are written.
+type AttrOrder int
+
+const (
+	// Accepted values for AttrOrder:
+	AttrOrderWritten = AttrOrder(iota) // the order of Attr calls (default)
+	AttrOrderSorted                    // alphabetical order of keys
+)
+
+// DupAttr selects how the printer handles attributes that are written more
+// than once within a tag.
+type DupAttr int
+
+const (
+	// Accepted values for DupAttr:
+	DupAttrError    = DupAttr(iota) // fail with ErrDuplicateAttr (default)
+	DupAttrKeepLast                 // the last value replaces the previous ones
+	DupAttrMerge                    // values are joined with separators, see WithAttrMerge
+)
+
+// NewPrinter creates a new FullPrinter for writing XML files.
 //
 // The tagger parameter is a callback that allows to customize indentation for
 // certain tags. If tagger is nil, then all the tags will be treated as block
-// level tags.
-func NewPrinter(indenter IndentStyle, putter func([]byte), tagger func(string) TagKind) Printer {
-	return &printer_impl{
-		put:         putter,
+// level tags. Additional configuration can be specified with opts.
+func NewPrinter(indenter IndentStyle, putter func([]byte), tagger func(string) TagKind, opts ...PrinterOption) FullPrinter {
+	p := &printer_impl{
+		out:         putter,
 		indent:      indenter,
 		on_tag_kind: tagger,
 	}
+	p.init(opts)
+	return p
+}
+
+// StreamPrinter is a FullPrinter that writes into an io.Writer through an
+// internal buffer.
+type StreamPrinter interface {
+	FullPrinter
+
+	// Flush writes any buffered data into the underlying io.Writer. It returns
+	// the first error recorded by the printer, including write errors.
+	Flush() error
+}
+
+// NewStreamPrinter creates a new Printer for writing XML files into out. The
+// output is buffered, call Flush() once done writing. The first error
+// returned by out is recorded by the printer and is available with Err(), all
+// subsequent printing calls are ignored. Write errors are recorded without
+// panicking regardless of the WithErrorMode option.
+//
+// See NewPrinter for the description of the indenter and tagger parameters.
+func NewStreamPrinter(out io.Writer, indenter IndentStyle, tagger func(string) TagKind, opts ...PrinterOption) StreamPrinter {
+	p := &printer_impl{
+		indent:      indenter,
+		on_tag_kind: tagger,
+	}
+	p.init(opts)
+	if p.buf_size > 0 {
+		p.bw = bufio.NewWriterSize(out, p.buf_size)
+	} else {
+		p.bw = bufio.NewWriter(out)
+	}
+	p.out = p.write
+	return p
 }
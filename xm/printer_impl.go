@@ -1,43 +1,284 @@
 package xm
 
 import (
+	"bufio"
 	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type printer_impl struct {
-	put          func([]byte)
-	names        []string // stack of tag names, used for closing tags
-	block_level  int
-	inline_level int
-	inline_mode  bool
-	in_tag       bool
-	eols         int
-	indent       IndentStyle
-	flags        PrinterFlags
-	on_tag_kind  func(n string) TagKind
+	out           func([]byte)
+	started       bool        // set once anything besides BOM is written
+	names         []string    // stack of tag names, used for closing tags
+	kinds         []TagKind   // stack of tag kinds, parallel to names
+	serials       []uint64    // stack of tag serial numbers, parallel to names
+	serial        uint64      // serial number of the last opened tag
+	pre_depth     int         // stack depth of the outermost preformatted tag, 0 if none
+	scopes        [][]binding // namespace bindings declared by the open tags
+	prefixes      map[string]string
+	ns_prefixes   map[string]string // generated prefixes, reused for later declarations
+	ns_counter    int
+	block_level   int
+	inline_level  int
+	inline_mode   bool
+	in_tag        bool
+	attrs         []attr // attributes of the open tag, written when the tag is finalized
+	deferred      []deferredTag
+	eols          int
+	col           int // current output column, only tracked with track_col
+	track_col     bool
+	line_indent   int // length of the indentation written at the start of the current line
+	max_width     int
+	attr_width    int
+	attr_count    int
+	attr_align    AttrAlign
+	attr_order    AttrOrder
+	attr_priority map[string]int
+	dup_attrs     DupAttr
+	attr_merge    map[string]string
+	indent        IndentStyle
+	indent_str    string // indentation for a single block level
+	indent_cache  string // indent_str repeated for multiple block levels
+	newline       string
+	quote         byte
+	refs          RefStyle
+	chars         CharSet
+	invalid       InvalidAction
+	ascii         bool
+	html          bool
+	self_close    SelfCloseStyle
+	custom_ind    bool
+	flags         PrinterFlags
+	error_mode    bool
+	err           error
+	bw            *bufio.Writer // output buffer for stream printers
+	buf_size      int
+	auto_close    bool
+	strict        bool
+	root_seen     bool
+	closed        bool
+	on_tag_kind   func(n string) TagKind
+}
+
+// init applies options and fills in the defaults.
+func (p *printer_impl) init(opts []PrinterOption) {
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.html && p.on_tag_kind == nil {
+		p.on_tag_kind = HTMLTagger
+	}
+	p.track_col = p.max_width > 0 || p.attr_width > 0 || p.attr_count > 0
+	if p.newline == "" {
+		p.newline = "\n"
+	}
+	if p.quote != '"' {
+		p.quote = AttrQuotationMark
+	}
+	if p.custom_ind {
+		if p.indent == IndentNone {
+			p.indent = IndentTabs
+		}
+	} else if p.indent == IndentTabs {
+		p.indent_str = "\t"
+	} else if p.indent > 0 {
+		p.indent_str = strings.Repeat(" ", int(p.indent))
+	}
+}
+
+func (p *printer_impl) Scrambler() Scrambler {
+	sc := Scrambler{Quote: p.quote, Refs: p.refs, Chars: p.chars, Invalid: p.invalid, ASCII: p.ascii, HTML: p.html}
+	if n := len(p.kinds); p.html && n > 0 && p.kinds[n-1] == RawText {
+		sc.RawText = p.names[n-1]
+	}
+	return sc
+}
+
+// inVoid reports whether the innermost open tag is an HTML void element.
+func (p *printer_impl) inVoid() bool {
+	n := len(p.names)
+	return p.html && n > 0 && html_void[strings.ToLower(p.names[n-1])]
+}
+
+func (p *printer_impl) put(b []byte) {
+	p.started = true
+	if p.track_col {
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			p.col = utf8.RuneCount(b[i+1:])
+		} else {
+			p.col += utf8.RuneCount(b)
+		}
+	}
+	p.out(b)
 }
 
 func (p *printer_impl) ln(n int) {
+	if p.pre_depth > 0 {
+		return // whitespace is significant in preformatted tags
+	}
 	if n > p.eols {
 		p.eols = n
 	}
 }
 
+func (p *printer_impl) Err() error {
+	return p.err
+}
+
+func (p *printer_impl) Fail(err error) {
+	p.record(err)
+	if !p.error_mode {
+		panic(p.err)
+	}
+}
+
+// record stores err as the printer error, unless another error was recorded
+// before.
+func (p *printer_impl) record(err error) {
+	if p.err == nil {
+		if e, ok := err.(*TagError); ok {
+			p.err = e
+		} else {
+			p.err = &TagError{Path: append([]string(nil), p.names...), Err: err}
+		}
+	}
+}
+
+func (p *printer_impl) Close() error {
+	if p.closed {
+		return p.err
+	}
+	p.closed = true
+	if p.err == nil && len(p.names) > 0 {
+		if p.auto_close {
+			for len(p.names) > 0 && p.err == nil {
+				p.CTag()
+			}
+		} else {
+			p.record(ErrUnclosedTag)
+		}
+	}
+	if p.err == nil && p.strict && !p.root_seen {
+		p.record(ErrNoRoot)
+	}
+	if p.err == nil && p.started {
+		p.put([]byte(p.newline))
+	}
+	err := p.Flush()
+	p.out = func([]byte) {}
+	return err
+}
+
+// write is the putter for stream printers.
+func (p *printer_impl) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	if _, err := p.bw.Write(b); err != nil {
+		p.record(err)
+	}
+}
+
+func (p *printer_impl) Flush() error {
+	if p.bw != nil {
+		if err := p.bw.Flush(); err != nil {
+			p.record(err)
+		}
+	}
+	return p.err
+}
+
 func (p *printer_impl) BOM() {
-	p.put([]byte("\uFEFF")) // writes \xef\xbb\xbf
+	if p.err != nil {
+		return
+	}
+	p.out([]byte("\uFEFF")) // writes \xef\xbb\xbf
 }
 
 func (p *printer_impl) XmlDecl() {
-	if len(p.names) > 0 {
-		panic("xml writer: invalid XmlDecl placement")
+	p.Decl(Decl{})
+}
+
+func (p *printer_impl) Decl(d Decl) {
+	if p.err != nil {
+		return
 	}
-	p.put([]byte("<?xml version='1.0' encoding='UTF-8'?>"))
+	if len(p.names) > 0 || p.started {
+		p.Fail(ErrDeclPlacement)
+		return
+	}
+	if d.Version == "" {
+		d.Version = "1.0"
+	}
+	if d.Encoding == "" {
+		d.Encoding = "UTF-8"
+	}
+	if (d.Version != "1.0" && d.Version != "1.1") || !validEncName(d.Encoding) {
+		p.Fail(ErrInvalidDecl)
+		return
+	}
+	q := string(p.quote)
+	s := "<?xml version=" + q + d.Version + q + " encoding=" + q + d.Encoding + q
+	if d.Standalone {
+		s += " standalone=" + q + "yes" + q
+	}
+	p.put([]byte(s + "?>"))
 	p.ln(1)
 }
 
+// validEncName checks the EncName production of the XML specification:
+// [A-Za-z] ([A-Za-z0-9._] | '-')*
+func validEncName(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '.' || c == '_' || c == '-'):
+		default:
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+func (p *printer_impl) Prolog(raw RawCont) {
+	if p.err != nil {
+		return
+	}
+	if len(p.names) > 0 || p.root_seen {
+		p.Fail(ErrPrologPlacement)
+		return
+	}
+	p.Markup(Block, raw)
+}
+
 func (p *printer_impl) Content(s RawCont) {
+	if p.err != nil {
+		return
+	}
+	if p.strict && len(p.names) == 0 && len(bytes.TrimSpace(s)) > 0 {
+		p.Fail(ErrContentOutsideRoot)
+		return
+	}
+	if p.inVoid() {
+		p.Fail(ErrVoidContent)
+		return
+	}
+	if len(p.deferred) > 0 {
+		if len(s) == 0 {
+			return // empty content does not open deferred tags
+		}
+		p.materialize()
+		if p.err != nil {
+			return
+		}
+	}
 	if p.in_tag {
 		p.in_tag = false
+		p.putAttrs(1)
 		p.put([]byte(">"))
 	} else if !p.inline_mode {
 		p.ln(1)
@@ -45,19 +286,22 @@ func (p *printer_impl) Content(s RawCont) {
 	p.inline_mode = true
 
 	p.putIndent()
-	if p.flags&PreserveInlineWhitespace != 0 || p.indent == IndentNone {
+	if p.flags&PreserveInlineWhitespace != 0 || p.indent == IndentNone || p.verbatim() {
 		p.put(s)
 	} else {
 		// re-indent after linebreaks
 		i := bytes.IndexByte(s, '\n')
 		if i < 0 {
-			p.put(s)
+			p.putText(s)
 			return
 		}
 		for {
 			line := s[:i]
+			if p.newline == "\r\n" {
+				line = bytes.TrimSuffix(line, []byte{'\r'})
+			}
 			p.putIndent()
-			p.put(line)
+			p.putText(line)
 			s = s[i+1:]
 			i = bytes.IndexByte(s, '\n')
 			if i < 0 {
@@ -66,57 +310,368 @@ func (p *printer_impl) Content(s RawCont) {
 			} else if i == 0 {
 				// a special handler for '\n\n' sequences to avoid generating
 				// empty lines that only have spaces or tabs before the next '\n'
-				p.put([]byte{'\n'})
+				p.put([]byte(p.newline))
 			} else {
 				p.ln(1)
 			}
 		}
 		if len(s) > 0 {
 			p.putIndent()
-			p.put(s)
+			p.putText(s)
 		}
 	}
 }
 
+// putText writes a line of content. With max_width, the line is wrapped at
+// spaces, spaces within markup like <em class='a b'> are not considered.
+func (p *printer_impl) putText(s []byte) {
+	if p.max_width <= 0 {
+		p.put(s)
+		return
+	}
+	in_markup := false
+	next_space := func() int {
+		for i, c := range s {
+			switch {
+			case c == '<':
+				in_markup = true
+			case c == '>':
+				in_markup = false
+			case c == ' ' && !in_markup:
+				return i
+			}
+		}
+		return -1
+	}
+	spaced := false // s is preceded by a space that is not written yet
+	for {
+		i := next_space()
+		word := s
+		if i >= 0 {
+			word = s[:i]
+		}
+		if spaced {
+			if p.col+1+utf8.RuneCount(word) > p.max_width && p.col > p.block_level*len(p.indent_str) {
+				p.ln(1)
+				p.putIndent()
+			} else {
+				p.put([]byte{' '})
+			}
+		}
+		p.put(word)
+		if i < 0 {
+			return
+		}
+		s, spaced = s[i+1:], true
+	}
+}
+
 func (p *printer_impl) Linebreak() {
+	if p.err != nil {
+		return
+	}
 	if p.flags&PreserveInlineWhitespace == 0 {
 		p.ln(1)
 	} else {
-		p.put([]byte{'\n'})
+		p.put([]byte(p.newline))
 	}
 }
 
 func (p *printer_impl) StopInline() {
+	if p.err != nil {
+		return
+	}
 	if p.inline_level == 0 {
 		p.inline_mode = false
 	}
 }
 
 func (p *printer_impl) Attr(key string, val RawAttr) {
+	if p.err != nil {
+		return
+	}
 	if !p.in_tag {
-		panic("xml writer: invalid xml printer.Attr call")
+		p.Fail(ErrAttrPlacement)
+		return
+	}
+	if key == "xml:space" && string(val) == "preserve" {
+		p.preformat()
+	}
+	if val != nil {
+		// the value is buffered until the tag is finalized, keep a copy so
+		// that callers may reuse their buffers
+		val = append(make(RawAttr, 0, len(val)), val...)
+	}
+	a := attr{key, val, p.html && val == nil}
+	for i := range p.attrs {
+		if p.attrs[i].key == key {
+			p.mergeAttr(&p.attrs[i], a)
+			return
+		}
+	}
+	p.attrs = append(p.attrs, a)
+}
+
+// mergeAttr handles the attribute a that duplicates the attribute prev of the
+// open tag according to the dup_attrs policy.
+func (p *printer_impl) mergeAttr(prev *attr, a attr) {
+	switch p.dup_attrs {
+	case DupAttrKeepLast:
+		*prev = a
+	case DupAttrMerge:
+		sep, ok := p.attr_merge[a.key]
+		if !ok {
+			sep, ok = defaultAttrMerge[a.key]
+		}
+		switch {
+		case !ok || prev.bare || a.bare || len(prev.val) == 0:
+			*prev = a
+		case len(a.val) == 0:
+		default:
+			v := append(RawAttr(nil), prev.val...)
+			if t := strings.TrimSpace(sep); t == "" || !bytes.HasSuffix(v, []byte(t)) {
+				v = append(v, sep...)
+			}
+			prev.val = append(v, a.val...)
+		}
+	default:
+		p.Fail(ErrDuplicateAttr)
 	}
-	p.put([]byte{' '})
-	p.put([]byte(key))
-	p.put([]byte("='"))
-	p.put(val)
-	p.put([]byte{'\''})
+}
+
+// defaultAttrMerge holds separators for joining values of duplicate
+// attributes with DupAttrMerge.
+var defaultAttrMerge = map[string]string{
+	"class": " ",
+	"style": ";",
+}
+
+// attr is an attribute of the open tag.
+type attr struct {
+	key  string
+	val  RawAttr
+	bare bool // written without value
+}
+
+// isNSDecl reports whether key is a namespace declaration.
+func isNSDecl(key string) bool {
+	return key == "xmlns" || strings.HasPrefix(key, "xmlns:")
+}
+
+// hasOwnAttrs reports whether the open tag has attributes besides namespace
+// declarations.
+func (p *printer_impl) hasOwnAttrs() bool {
+	for _, a := range p.attrs {
+		if !isNSDecl(a.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// attrRank returns the position of key in the attribute order: namespace
+// declarations come first, prioritized keys follow, other keys come last.
+func (p *printer_impl) attrRank(key string) int {
+	if isNSDecl(key) {
+		return 0
+	}
+	if i, ok := p.attr_priority[key]; ok {
+		return 1 + i
+	}
+	return 1 + len(p.attr_priority)
+}
+
+// attrLess compares attribute keys according to the printer attribute order.
+func (p *printer_impl) attrLess(a, b string) bool {
+	ra, rb := p.attrRank(a), p.attrRank(b)
+	if ra != rb {
+		return ra < rb
+	}
+	return p.attr_order == AttrOrderSorted && ra > len(p.attr_priority) && a < b
+}
+
+// putAttrs writes the attributes of the open tag, tail is the length of the
+// tag terminator. Each attribute is placed on its own line if the tag exceeds
+// attr_width or has more than attr_count attributes.
+func (p *printer_impl) putAttrs(tail int) {
+	if p.attr_order != AttrOrderWritten || len(p.attr_priority) > 0 {
+		sort.SliceStable(p.attrs, func(i, j int) bool {
+			return p.attrLess(p.attrs[i].key, p.attrs[j].key)
+		})
+	}
+	wrap := false
+	if len(p.attrs) > 1 && p.indent != IndentNone {
+		if p.attr_count > 0 && len(p.attrs) > p.attr_count {
+			wrap = true
+		} else if p.attr_width > 0 {
+			w := p.col + tail
+			for _, a := range p.attrs {
+				w += 1 + utf8.RuneCountInString(a.key)
+				if !a.bare {
+					w += 3 + utf8.RuneCount(a.val)
+				}
+			}
+			wrap = w > p.attr_width
+		}
+	}
+	var sep []byte
+	if wrap {
+		sep = []byte(p.newline + p.indentString(p.line_indent))
+		if p.attr_align == AttrAlignIndent {
+			sep = append(sep, p.indent_str...)
+		} else {
+			sep = append(sep, strings.Repeat(" ", p.col+1-p.line_indent)...)
+		}
+	}
+	for i, a := range p.attrs {
+		if i > 0 && wrap {
+			p.put(sep)
+		} else {
+			p.put([]byte{' '})
+		}
+		p.put([]byte(a.key))
+		if !a.bare {
+			p.put([]byte{'=', p.quote})
+			p.put(a.val)
+			p.put([]byte{p.quote})
+		}
+	}
+	p.attrs = p.attrs[:0]
 }
 
 func (p *printer_impl) OTag(name string) {
+	if p.err != nil {
+		return
+	}
+	if len(name) == 0 {
+		p.Fail(ErrEmptyTagName)
+		return
+	}
+	if p.inVoid() {
+		p.Fail(ErrVoidContent)
+		return
+	}
+	p.materialize()
+	if p.err != nil {
+		return
+	}
+	k := p.kindOf(name)
+	if !p.startTag(name, k) {
+		return
+	}
+	p.names = append(p.names, name)
+	p.kinds = append(p.kinds, k)
+	p.serial++
+	p.serials = append(p.serials, p.serial)
+	p.scopes = append(p.scopes, nil)
+	if k == Preformatted {
+		p.preformat()
+	}
+}
+
+// startTag writes the beginning of the opening tag, it returns false if the
+// tag violates document structure.
+func (p *printer_impl) startTag(name string, k TagKind) bool {
+	if len(p.names) == 0 {
+		if p.strict && p.root_seen {
+			p.Fail(ErrMultipleRoots)
+			return false
+		}
+		p.root_seen = true
+	}
+	p.open(k)
+	p.put([]byte{'<'})
+	p.put([]byte(name))
+	p.in_tag = true
+	return true
+}
+
+// deferredTag is a tag opened with OptOTag that is not written yet.
+type deferredTag struct {
+	depth      int    // stack depth of the tag
+	omit_attrs bool   // omit the tag if it only has attributes
+	in_tag     bool   // in_tag state before the tag was opened
+	attrs      []attr // attributes of the enclosing tag before the tag was opened
+}
+
+func (p *printer_impl) OptOTag(name string, omitAttrs bool) {
+	if p.err != nil {
+		return
+	}
 	if len(name) == 0 {
-		panic("xml writer: trying to write a tag with empty name")
+		p.Fail(ErrEmptyTagName)
+		return
+	}
+	if p.inVoid() {
+		p.Fail(ErrVoidContent)
+		return
 	}
 	k := p.kindOf(name)
+	p.names = append(p.names, name)
+	p.kinds = append(p.kinds, k)
+	p.serial++
+	p.serials = append(p.serials, p.serial)
+	p.scopes = append(p.scopes, nil)
+	p.deferred = append(p.deferred, deferredTag{len(p.names), omitAttrs, p.in_tag, p.attrs})
+	p.in_tag, p.attrs = true, nil
+	if k == Preformatted {
+		p.preformat()
+	}
+}
 
+// materialize writes the opening tags that were deferred with OptOTag.
+func (p *printer_impl) materialize() {
+	if len(p.deferred) == 0 {
+		return
+	}
+	attrs := p.attrs
+	for _, d := range p.deferred {
+		p.in_tag, p.attrs = d.in_tag, d.attrs
+		pre_depth := p.pre_depth
+		if pre_depth >= d.depth {
+			p.pre_depth = 0 // preformatting starts within the tag
+		}
+		name := p.names[d.depth-1]
+		names := p.names
+		p.names = p.names[:d.depth-1]
+		ok := p.startTag(name, p.kinds[d.depth-1])
+		p.names = names
+		if !ok {
+			return
+		}
+		p.pre_depth = pre_depth
+	}
+	p.deferred = p.deferred[:0]
+	p.attrs = attrs
+}
+
+// preformat turns off indentation within the currently open tag and its
+// descendants.
+func (p *printer_impl) preformat() {
+	if p.pre_depth == 0 {
+		p.pre_depth = len(p.names)
+	}
+}
+
+// verbatim reports whether content is written without re-indentation.
+func (p *printer_impl) verbatim() bool {
+	n := len(p.kinds)
+	return p.pre_depth > 0 || (n > 0 && p.kinds[n-1] == RawText)
+}
+
+// open finalizes the currently open tag and updates indentation state for a
+// new element of kind k.
+func (p *printer_impl) open(k TagKind) {
 	was_in_tag := p.in_tag
 	if p.in_tag {
 		p.in_tag = false
+		p.putAttrs(1)
 		p.put([]byte{'>'})
 	}
 
 	if p.inline_level > 0 || k == Inline {
 		if p.inline_mode || was_in_tag {
+			p.inline_mode = true
 			p.inline_level++
 		} else {
 			p.ln(1)
@@ -132,19 +687,11 @@ func (p *printer_impl) OTag(name string) {
 		p.putIndent()
 		p.block_level++
 	}
-	p.put([]byte{'<'})
-	p.put([]byte(name))
-	p.in_tag = true
-	p.names = append(p.names, name)
 }
 
-func (p *printer_impl) CTag() {
-	stack_len := len(p.names)
-	if stack_len == 0 {
-		panic("xml writer: tag stack underflow, unpaired CTag call")
-	}
-	name := p.names[stack_len-1]
-
+// close updates indentation state when an element is closed, it returns true
+// if the element was written in inline mode.
+func (p *printer_impl) close() bool {
 	was_inline := p.inline_mode
 
 	if p.inline_mode {
@@ -157,14 +704,83 @@ func (p *printer_impl) CTag() {
 	} else {
 		p.block_level--
 	}
+	return was_inline
+}
+
+func (p *printer_impl) Markup(k TagKind, raw RawCont) {
+	if p.err != nil {
+		return
+	}
+	if p.inVoid() {
+		p.Fail(ErrVoidContent)
+		return
+	}
+	p.materialize()
+	if p.err != nil {
+		return
+	}
+	p.open(k)
+	p.put(raw)
+	p.close()
+}
+
+func (p *printer_impl) CTag() {
+	if p.err != nil {
+		return
+	}
+	stack_len := len(p.names)
+	if stack_len == 0 {
+		p.Fail(ErrUnpairedCTag)
+		return
+	}
+	name := p.names[stack_len-1]
 
 	pop_stack := func() {
 		p.names = p.names[:stack_len-1]
+		p.kinds = p.kinds[:stack_len-1]
+		p.serials = p.serials[:stack_len-1]
+		p.scopes = p.scopes[:stack_len-1]
+		if p.pre_depth == stack_len {
+			p.pre_depth = 0
+		}
 	}
 
+	if n := len(p.deferred); n > 0 && p.deferred[n-1].depth == stack_len {
+		d := p.deferred[n-1]
+		if d.omit_attrs || !p.hasOwnAttrs() {
+			// omit the tag
+			p.deferred = p.deferred[:n-1]
+			p.in_tag, p.attrs = d.in_tag, d.attrs
+			pop_stack()
+			return
+		}
+		p.materialize()
+		if p.err != nil {
+			return
+		}
+	}
+
+	was_inline := p.close()
+
 	if p.in_tag {
 		p.in_tag = false
-		p.put([]byte("/>"))
+		p.putAttrs(2)
+		switch {
+		case p.html && html_void[strings.ToLower(name)]:
+			p.put([]byte{'>'})
+		case p.html:
+			p.put([]byte("></"))
+			p.put([]byte(name))
+			p.put([]byte{'>'})
+		case p.self_close == SelfCloseSpaced:
+			p.put([]byte(" />"))
+		case p.self_close == SelfCloseNever:
+			p.put([]byte("></"))
+			p.put([]byte(name))
+			p.put([]byte{'>'})
+		default:
+			p.put([]byte("/>"))
+		}
 	} else {
 		if !was_inline {
 			p.ln(1)
@@ -178,44 +794,187 @@ func (p *printer_impl) CTag() {
 
 }
 
-const (
-	eols_8    = "\n\n\n\n\n\n\n\n"
-	tabs_8    = "\t\t\t\t\t\t\t\t"
-	spaces_16 = "                "
-)
+func (p *printer_impl) OpenTags() []string {
+	return p.names
+}
+
+// tagSerial implements serialPrinter.
+func (p *printer_impl) tagSerial() uint64 {
+	if n := len(p.serials); n > 0 {
+		return p.serials[n-1]
+	}
+	return 0
+}
 
 func (p *printer_impl) putIndent() {
+	if !p.started || p.pre_depth > 0 {
+		p.eols = 0 // no leading newlines at the top of the document or in preformatted tags
+	}
 	if p.indent == IndentNone || p.eols == 0 {
 		return
 	}
 
-	for p.eols > 8 {
-		p.put([]byte(eols_8))
-		p.eols -= 8
+	for ; p.eols > 0; p.eols-- {
+		p.put([]byte(p.newline))
 	}
-	p.put([]byte(eols_8[:p.eols]))
-	p.eols = 0
 
-	if p.indent == IndentTabs {
-		n := p.block_level
-		for n > 8 {
-			p.put([]byte(tabs_8))
-			n -= 8
-		}
-		p.put([]byte(tabs_8[:n]))
-	} else {
-		n := p.block_level * int(p.indent)
-		for n > 16 {
-			p.put([]byte(spaces_16))
-			n -= 16
-		}
-		p.put([]byte(spaces_16[:n]))
+	n := p.block_level * len(p.indent_str)
+	p.line_indent = n
+	if n == 0 {
+		return
 	}
+	p.put([]byte(p.indentString(n)))
+}
+
+// indentString returns n bytes of indentation.
+func (p *printer_impl) indentString(n int) string {
+	if len(p.indent_cache) < n {
+		p.indent_cache = strings.Repeat(p.indent_str, 2*n/len(p.indent_str))
+	}
+	return p.indent_cache[:n]
 }
 
 func (p *printer_impl) kindOf(n string) TagKind {
+	if p.html && html_raw_text[strings.ToLower(n)] {
+		return RawText
+	}
 	if p.on_tag_kind != nil {
 		return p.on_tag_kind(n)
 	}
 	return Block
 }
+
+// binding associates a namespace prefix with a uri.
+type binding struct {
+	prefix string
+	uri    string
+}
+
+// lookupPrefix finds the innermost prefix that is bound to uri and is not
+// shadowed by other bindings. The default namespace is skipped for attributes.
+func (p *printer_impl) lookupPrefix(uri string, attr bool) (string, bool) {
+	if uri == XMLNamespace {
+		return "xml", true
+	}
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		for _, b := range p.scopes[i] {
+			if b.uri == uri && !(attr && b.prefix == "") && p.resolvePrefix(b.prefix) == uri {
+				return b.prefix, true
+			}
+		}
+	}
+	return "", false
+}
+
+// resolvePrefix returns the uri that is currently bound to prefix.
+func (p *printer_impl) resolvePrefix(prefix string) string {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		for _, b := range p.scopes[i] {
+			if b.prefix == prefix {
+				return b.uri
+			}
+		}
+	}
+	return ""
+}
+
+// declare binds prefix to uri on the currently open tag.
+func (p *printer_impl) declare(prefix, uri string) {
+	top := len(p.scopes) - 1
+	p.scopes[top] = append(p.scopes[top], binding{prefix, uri})
+	if prefix == "" {
+		p.Attr("xmlns", scrambleAttr(p, uri))
+	} else {
+		p.Attr("xmlns:"+prefix, scrambleAttr(p, uri))
+	}
+}
+
+// choosePrefix returns the prefix for a new declaration of uri. Prefixes
+// generated for uri are reused while they are not bound to other namespaces.
+func (p *printer_impl) choosePrefix(uri string, attr bool) string {
+	if prefix, ok := p.prefixes[uri]; ok && !(attr && prefix == "") {
+		if prefix == "" || p.resolvePrefix(prefix) == "" {
+			return prefix
+		}
+	}
+	if prefix, ok := p.ns_prefixes[uri]; ok && p.resolvePrefix(prefix) == "" {
+		return prefix
+	}
+	for {
+		prefix := "ns" + strconv.Itoa(p.ns_counter)
+		p.ns_counter++
+		if p.resolvePrefix(prefix) == "" {
+			if p.ns_prefixes == nil {
+				p.ns_prefixes = map[string]string{}
+			}
+			p.ns_prefixes[uri] = prefix
+			return prefix
+		}
+	}
+}
+
+func qualify(prefix, local string) string {
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+func (p *printer_impl) PreferPrefix(uri, prefix string) {
+	if p.prefixes == nil {
+		p.prefixes = map[string]string{}
+	}
+	p.prefixes[uri] = prefix
+}
+
+func (p *printer_impl) NSOTag(uri, local string) {
+	p.nsOpen(uri, local, p.OTag)
+}
+
+func (p *printer_impl) NSOptOTag(uri, local string, omitAttrs bool) {
+	p.nsOpen(uri, local, func(name string) { p.OptOTag(name, omitAttrs) })
+}
+
+// nsOpen opens a tag with open, qualifying the local name with a prefix bound
+// to uri, and declares the binding if necessary.
+func (p *printer_impl) nsOpen(uri, local string, open func(name string)) {
+	if p.err != nil {
+		return
+	}
+	if uri == "" {
+		open(local)
+		if p.err == nil && p.resolvePrefix("") != "" {
+			p.declare("", "")
+		}
+		return
+	}
+	if prefix, ok := p.lookupPrefix(uri, false); ok {
+		open(qualify(prefix, local))
+		return
+	}
+	prefix := p.choosePrefix(uri, false)
+	open(qualify(prefix, local))
+	if p.err == nil {
+		p.declare(prefix, uri)
+	}
+}
+
+func (p *printer_impl) NSAttr(uri, local string, val RawAttr) {
+	if p.err != nil {
+		return
+	}
+	if !p.in_tag {
+		p.Fail(ErrAttrPlacement)
+		return
+	}
+	if uri == "" {
+		p.Attr(local, val)
+		return
+	}
+	prefix, ok := p.lookupPrefix(uri, true)
+	if !ok {
+		prefix = p.choosePrefix(uri, true)
+		p.declare(prefix, uri)
+	}
+	p.Attr(qualify(prefix, local), val)
+}
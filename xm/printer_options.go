@@ -0,0 +1,200 @@
+package xm
+
+// PrinterOption customizes the Printer created with NewPrinter.
+type PrinterOption func(*printer_impl)
+
+// WithErrorMode makes the printer record errors instead of panicking. The first
+// error is available with ErrPrinter.Err(), all subsequent printing calls are
+// ignored.
+func WithErrorMode() PrinterOption {
+	return func(p *printer_impl) {
+		p.error_mode = true
+	}
+}
+
+// WithAutoClose makes Close() automatically close all open tags.
+func WithAutoClose() PrinterOption {
+	return func(p *printer_impl) {
+		p.auto_close = true
+	}
+}
+
+// WithStrictDocument enables enforcement of XML document structure: the
+// document must have exactly one root tag, and only whitespace, comments and
+// processing instructions are allowed outside of it.
+func WithStrictDocument() PrinterOption {
+	return func(p *printer_impl) {
+		p.strict = true
+	}
+}
+
+// WithBufferSize sets the size of the internal buffer used by printers created
+// with NewStreamPrinter.
+func WithBufferSize(n int) PrinterOption {
+	return func(p *printer_impl) {
+		p.buf_size = n
+	}
+}
+
+// WithIndentStyle overrides the indentation style.
+func WithIndentStyle(s IndentStyle) PrinterOption {
+	return func(p *printer_impl) {
+		p.indent = s
+		p.custom_ind = false
+	}
+}
+
+// WithIndentString sets an arbitrary string that is used for indenting each
+// block level, for example "\t\t" or "   ".
+func WithIndentString(s string) PrinterOption {
+	return func(p *printer_impl) {
+		p.indent_str = s
+		p.custom_ind = true
+	}
+}
+
+// WithTagger sets the callback that customizes indentation for certain tags,
+// see NewPrinter.
+func WithTagger(tagger func(string) TagKind) PrinterOption {
+	return func(p *printer_impl) {
+		p.on_tag_kind = tagger
+	}
+}
+
+// WithNewline sets the line ending sequence, typically "\n" (default) or
+// "\r\n".
+func WithNewline(s string) PrinterOption {
+	return func(p *printer_impl) {
+		p.newline = s
+	}
+}
+
+// WithQuote sets the quotation mark for attribute values, either a single
+// quote (default) or a double quote. The printer's Scrambler is adjusted to
+// match.
+func WithQuote(q byte) PrinterOption {
+	return func(p *printer_impl) {
+		p.quote = q
+	}
+}
+
+// WithSelfClose configures how empty tags are written.
+func WithSelfClose(s SelfCloseStyle) PrinterOption {
+	return func(p *printer_impl) {
+		p.self_close = s
+	}
+}
+
+// WithFlags sets printer flags.
+func WithFlags(f PrinterFlags) PrinterOption {
+	return func(p *printer_impl) {
+		p.flags = f
+	}
+}
+
+// WithRefStyle selects the syntax of character references produced by the
+// printer's Scrambler.
+func WithRefStyle(r RefStyle) PrinterOption {
+	return func(p *printer_impl) {
+		p.refs = r
+	}
+}
+
+// WithHTML enables HTML5 serialization:
+//
+//   - void elements like <br> are written without closing tags, writing
+//     content into them fails with ErrVoidContent
+//   - other empty elements are written as <div></div>
+//   - content of raw text elements, like <script> and <style>, is written
+//     without escaping
+//   - attributes with nil values are written without values, Writer writes
+//     true booleans this way and omits false ones
+//   - the printer's Scrambler uses HTML escaping
+//   - HTMLTagger is used if no tagger is specified
+//
+// Use HTMLDoctype to write the <!DOCTYPE html> declaration.
+func WithHTML() PrinterOption {
+	return func(p *printer_impl) {
+		p.html = true
+	}
+}
+
+// WithMaxWidth enables wrapping of text content at spaces, so that lines do
+// not exceed n columns where possible. Wrapped lines are indented to the
+// current block level. Tags and character references are never split, and
+// content of Preformatted and RawText tags is not wrapped. Wrapping has no
+// effect with IndentNone or the PreserveInlineWhitespace flag.
+func WithMaxWidth(n int) PrinterOption {
+	return func(p *printer_impl) {
+		p.max_width = n
+	}
+}
+
+// WithAttrWrap places each attribute of an opening tag on its own line when
+// the tag would exceed width columns, or when it has more than count
+// attributes. The first attribute stays on the line with the tag name, the
+// placement of other attributes is selected with align. Zero width or count
+// disables the respective check. Attributes are not wrapped with IndentNone.
+func WithAttrWrap(width, count int, align AttrAlign) PrinterOption {
+	return func(p *printer_impl) {
+		p.attr_width = width
+		p.attr_count = count
+		p.attr_align = align
+	}
+}
+
+// WithAttrOrder selects the order of attributes within tags. Attributes with
+// keys listed in priority are written first, in the order of the list, other
+// attributes follow in the specified order. When attributes are reordered,
+// namespace declarations are written before all other attributes.
+func WithAttrOrder(order AttrOrder, priority ...string) PrinterOption {
+	return func(p *printer_impl) {
+		p.attr_order = order
+		p.attr_priority = make(map[string]int, len(priority))
+		for i, k := range priority {
+			if _, dup := p.attr_priority[k]; !dup {
+				p.attr_priority[k] = i
+			}
+		}
+	}
+}
+
+// WithDuplicateAttrs selects how attributes that are written more than once
+// within a tag are handled. Duplicates are written in the position of the
+// first occurrence.
+func WithDuplicateAttrs(d DupAttr) PrinterOption {
+	return func(p *printer_impl) {
+		p.dup_attrs = d
+	}
+}
+
+// WithAttrMerge sets the separator for joining values of duplicate key
+// attributes with DupAttrMerge. By default, class values are joined with " ",
+// and style values are joined with ";". Duplicates of attributes without
+// separators keep the last value.
+func WithAttrMerge(key, sep string) PrinterOption {
+	return func(p *printer_impl) {
+		if p.attr_merge == nil {
+			p.attr_merge = map[string]string{}
+		}
+		p.attr_merge[key] = sep
+	}
+}
+
+// WithCharValidation makes the printer's Scrambler validate characters against
+// the specified character set, invalid characters are either replaced or
+// rejected.
+func WithCharValidation(chars CharSet, invalid InvalidAction) PrinterOption {
+	return func(p *printer_impl) {
+		p.chars = chars
+		p.invalid = invalid
+	}
+}
+
+// WithASCII makes the printer's Scrambler write all non-ASCII characters as
+// numeric character references.
+func WithASCII() PrinterOption {
+	return func(p *printer_impl) {
+		p.ascii = true
+	}
+}
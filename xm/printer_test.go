@@ -2,7 +2,10 @@ package xm
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"testing"
 )
 
 func ExamplePrinter() {
@@ -99,3 +102,364 @@ path {
 	//   </style>
 	// </root>
 }
+
+type limitedWriter struct {
+	n      int // remaining capacity
+	writes int
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	w.writes++
+	if len(b) > w.n {
+		n := w.n
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(b)
+	return len(b), nil
+}
+
+func TestStreamPrinter(t *testing.T) {
+	out := &limitedWriter{n: 1 << 20}
+	p := NewStreamPrinter(out, IndentNone, nil)
+	for i := 0; i < 100; i++ {
+		p.OTag("item")
+		p.Content(RawCont("text"))
+		p.CTag()
+	}
+	if out.writes != 0 {
+		t.Errorf("expected buffered output, got %d writes before Flush", out.writes)
+	}
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.writes != 1 {
+		t.Errorf("got %d writes, want 1", out.writes)
+	}
+
+	out = &limitedWriter{n: 100}
+	p = NewStreamPrinter(out, IndentNone, nil, WithBufferSize(16))
+	for i := 0; i < 100; i++ {
+		p.OTag("item")
+		p.CTag()
+	}
+	if err := p.Err(); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("got %v, want %v", err, io.ErrShortWrite)
+	}
+	if err := p.Flush(); !errors.Is(err, io.ErrShortWrite) {
+		t.Errorf("got %v, want %v from Flush", err, io.ErrShortWrite)
+	}
+}
+
+func TestPrinterClose(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []PrinterOption
+		f    func(p FullPrinter)
+		want string
+		err  error
+	}{
+		{"empty", nil, func(p FullPrinter) {}, "", nil},
+		{"final newline", nil, func(p FullPrinter) { p.OTag("a"); p.CTag() }, "<a/>\n", nil},
+		{"unclosed", nil, func(p FullPrinter) { p.OTag("a"); p.OTag("b") }, "<a>\n  <b", ErrUnclosedTag},
+		{"auto close", []PrinterOption{WithAutoClose()},
+			func(p FullPrinter) { p.OTag("a"); p.OTag("b") }, "<a>\n  <b/>\n</a>\n", nil},
+		{"decl after content", []PrinterOption{WithErrorMode()},
+			func(p FullPrinter) { p.Content(RawCont(" ")); p.XmlDecl() }, " ", ErrDeclPlacement},
+		{"decl after bom", nil,
+			func(p FullPrinter) { p.BOM(); p.XmlDecl(); p.OTag("a"); p.CTag() },
+			"\uFEFF<?xml version='1.0' encoding='UTF-8'?>\n<a/>\n", nil},
+		{"two roots", nil, func(p FullPrinter) { p.OTag("a"); p.CTag(); p.OTag("b"); p.CTag() }, "<a/>\n<b/>\n", nil},
+		{"strict two roots", []PrinterOption{WithStrictDocument(), WithErrorMode()},
+			func(p FullPrinter) { p.OTag("a"); p.CTag(); p.OTag("b"); p.CTag() }, "<a/>", ErrMultipleRoots},
+		{"strict no root", []PrinterOption{WithStrictDocument()},
+			func(p FullPrinter) { p.Markup(Block, RawCont("<!---->")) }, "<!---->", ErrNoRoot},
+		{"strict epilog text", []PrinterOption{WithStrictDocument(), WithErrorMode()},
+			func(p FullPrinter) { p.OTag("a"); p.CTag(); p.Content(RawCont("\n")); p.Content(RawCont("text")) },
+			"<a/>\n", ErrContentOutsideRoot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil, tt.opts...)
+			tt.f(p)
+			err := p.Close()
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got error %v, want %v", err, tt.err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinterRecoveredPanic(t *testing.T) {
+	buf := bytes.Buffer{}
+	p := NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil)
+	p.OTag("a")
+	func() {
+		defer func() {
+			if r := recover(); !errors.Is(r.(error), ErrEmptyTagName) {
+				t.Errorf("recovered %v, want %v", r, ErrEmptyTagName)
+			}
+		}()
+		p.OTag("")
+	}()
+
+	// the printer stays failed after the panic
+	p.OTag("b")
+	p.CTag()
+	if err := p.Close(); !errors.Is(err, ErrEmptyTagName) {
+		t.Errorf("got error %v, want %v", err, ErrEmptyTagName)
+	}
+	if got, want := buf.String(), "<a"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrinterOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []PrinterOption
+		want string
+	}{
+		{"defaults", nil,
+			"<root a='x&apos;y\"z'>\n  <empty/>\n  <p>line\n    break</p>\n</root>\n"},
+		{"double quotes", []PrinterOption{WithQuote('"')},
+			"<root a=\"x'y&quot;z\">\n  <empty/>\n  <p>line\n    break</p>\n</root>\n"},
+		{"crlf and custom indent", []PrinterOption{WithNewline("\r\n"), WithIndentString("   ")},
+			"<root a='x&apos;y\"z'>\r\n   <empty/>\r\n   <p>line\r\n      break</p>\r\n</root>\r\n"},
+		{"spaced self close", []PrinterOption{WithSelfClose(SelfCloseSpaced), WithIndentStyle(IndentNone)},
+			"<root a='x&apos;y\"z'><empty /><p>line\nbreak</p></root>\n"},
+		{"never self close", []PrinterOption{WithSelfClose(SelfCloseNever), WithIndentStyle(IndentTabs)},
+			"<root a='x&apos;y\"z'>\n\t<empty></empty>\n\t<p>line\n\t\tbreak</p>\n</root>\n"},
+		{"flags", []PrinterOption{WithFlags(PreserveInlineWhitespace)},
+			"<root a='x&apos;y\"z'>\n  <empty/>\n  <p>line\nbreak</p>\n</root>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil, tt.opts...)
+			w := NewWriter(p)
+			w.Tag("root", Attr("a", "x'y\"z"), Tag("empty"), Tag("p", "line\nbreak"))
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinterTagKinds(t *testing.T) {
+	tagger := func(n string) TagKind {
+		switch n {
+		case "pre":
+			return Preformatted
+		case "raw":
+			return RawText
+		case "em":
+			return Inline
+		}
+		return Block
+	}
+	tests := []struct {
+		name string
+		f    func(w Writer)
+		want string
+	}{
+		{"preformatted",
+			func(w Writer) { w.Tag("pre", "line\n  indented\n", Tag("em", "x"), Tag("div", "y"), "\nend") },
+			"<root>\n  <pre>line\n  indented\n<em>x</em><div>y</div>\nend</pre>\n  <p/>\n</root>\n"},
+		{"empty preformatted", func(w Writer) { w.Tag("pre") },
+			"<root>\n  <pre/>\n  <p/>\n</root>\n"},
+		{"xml:space", func(w Writer) { w.Tag("div", Attr("xml:space", "preserve"), Tag("b", "a\nb")) },
+			"<root>\n  <div xml:space='preserve'><b>a\nb</b></div>\n  <p/>\n</root>\n"},
+		{"xml:space default", func(w Writer) { w.Tag("div", Attr("xml:space", "default"), Tag("b", "a\nb")) },
+			"<root>\n  <div xml:space='default'>\n    <b>a\n      b</b>\n  </div>\n  <p/>\n</root>\n"},
+		{"raw text", func(w Writer) { w.Tag("raw", "\na < b\n  c\n") },
+			"<root>\n  <raw>\na &lt; b\n  c\n</raw>\n  <p/>\n</root>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, tagger)
+			w := NewWriter(p)
+			w.Tag("root", tt.f, Tag("p"))
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinterMaxWidth(t *testing.T) {
+	tagger := func(n string) TagKind {
+		if n == "em" || n == "a" {
+			return Inline
+		}
+		return Block
+	}
+	tests := []struct {
+		name string
+		f    func(w Writer)
+		want string
+	}{
+		{"plain",
+			func(w Writer) { w.Tag("p", "The quick brown fox jumps over the lazy dog") },
+			"<p>The quick brown fox\n    jumps over the lazy\n    dog</p>"},
+		{"long word",
+			func(w Writer) { w.Tag("p", "a Pneumonoultramicroscopicsilicovolcanoconiosis b") },
+			"<p>a\n    Pneumonoultramicroscopicsilicovolcanoconiosis\n    b</p>"},
+		{"refs and inline tags",
+			func(w Writer) {
+				w.Tag("p", "Fish & chips & more ", Tag("a", Attr("title", "x y z"), "link text"), " and the rest")
+			},
+			"<p>Fish &amp; chips\n    &amp; more <a title='x y z'>link\n    text</a> and the\n    rest</p>"},
+		{"raw markup", func(w Writer) { w.Tag("p", RawCont("abc <em class='a b c d e f'>x</em>")) },
+			"<p>abc\n    <em class='a b c d e f'>x</em></p>"},
+		{"preformatted", func(w Writer) {
+			w.Tag("p", Attr("xml:space", "preserve"), "The quick brown fox jumps over the lazy dog")
+		}, "<p xml:space='preserve'>The quick brown fox jumps over the lazy dog</p>"},
+		{"existing linebreaks", func(w Writer) { w.Tag("p", "short\nThe quick brown fox jumps") },
+			"<p>short\n    The quick brown fox\n    jumps</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, tagger, WithMaxWidth(24))
+			w := NewWriter(p)
+			w.Tag("root", tt.f)
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			want := "<root>\n  " + tt.want + "\n</root>\n"
+			if got := buf.String(); got != want {
+				t.Errorf("got  %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPrinterAttrWrap(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []PrinterOption
+		want string
+	}{
+		{"off", nil,
+			"<root>\n  <item a='1' bb='22' ccc='333'/>\n  <item a='1'>text</item>\n</root>\n"},
+		{"count aligned", []PrinterOption{WithAttrWrap(0, 2, AttrAlignFirst)},
+			"<root>\n  <item a='1'\n        bb='22'\n        ccc='333'/>\n  <item a='1'>text</item>\n</root>\n"},
+		{"count indented", []PrinterOption{WithAttrWrap(0, 2, AttrAlignIndent)},
+			"<root>\n  <item a='1'\n    bb='22'\n    ccc='333'/>\n  <item a='1'>text</item>\n</root>\n"},
+		{"width", []PrinterOption{WithAttrWrap(30, 0, AttrAlignIndent), WithIndentStyle(IndentTabs)},
+			"<root>\n\t<item a='1'\n\t\tbb='22'\n\t\tccc='333'/>\n\t<item a='1'>text</item>\n</root>\n"},
+		{"width fits", []PrinterOption{WithAttrWrap(33, 0, AttrAlignIndent)},
+			"<root>\n  <item a='1' bb='22' ccc='333'/>\n  <item a='1'>text</item>\n</root>\n"},
+		{"no indentation", []PrinterOption{WithAttrWrap(0, 1, AttrAlignFirst), WithIndentStyle(IndentNone)},
+			"<root><item a='1' bb='22' ccc='333'/><item a='1'>text</item></root>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil, tt.opts...)
+			w := NewWriter(p)
+			w.Tag("root",
+				Tag("item", Attr("a", 1), Attr("bb", 22), Attr("ccc", 333)),
+				Tag("item", Attr("a", 1), "text"))
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinterAttrOrder(t *testing.T) {
+	attrs := AttrList{{"name", "n"}, {"class", "c"}, {"b", 2}, {"xmlns:x", "urn:x"}, {"id", "i"}, {"a", 1}}
+	tests := []struct {
+		name string
+		opts []PrinterOption
+		want string
+	}{
+		{"written", nil,
+			"<t name='n' class='c' b='2' xmlns:x='urn:x' id='i' a='1'/>\n"},
+		{"sorted", []PrinterOption{WithAttrOrder(AttrOrderSorted)},
+			"<t xmlns:x='urn:x' a='1' b='2' class='c' id='i' name='n'/>\n"},
+		{"priority", []PrinterOption{WithAttrOrder(AttrOrderWritten, "id", "class", "name")},
+			"<t xmlns:x='urn:x' id='i' class='c' name='n' b='2' a='1'/>\n"},
+		{"priority sorted", []PrinterOption{WithAttrOrder(AttrOrderSorted, "id", "class")},
+			"<t xmlns:x='urn:x' id='i' class='c' a='1' b='2' name='n'/>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil, tt.opts...)
+			NewWriter(p).Tag("t", attrs)
+			if err := p.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrinterAttrBuffer(t *testing.T) {
+	buf := bytes.Buffer{}
+	p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil)
+	val := RawAttr("a")
+	p.OTag("t")
+	p.Attr("k1", val)
+	val[0] = 'b'
+	p.Attr("k2", val)
+	val[0] = 'c'
+	p.CTag()
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "<t k1='a' k2='b'/>\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestPrinterDuplicateAttrs(t *testing.T) {
+	attrs := AttrList{{"class", "a"}, {"id", "x"}, {"style", "color:red;"}, {"class", "b"},
+		{"style", "margin:0"}, {"id", "y"}, {"rel", "r1"}, {"rel", "r2"}}
+	tests := []struct {
+		name string
+		opts []PrinterOption
+		want string
+		err  error
+	}{
+		{"error", nil, "<t", ErrDuplicateAttr},
+		{"keep last", []PrinterOption{WithDuplicateAttrs(DupAttrKeepLast)},
+			"<t class='b' id='y' style='margin:0' rel='r2'/>\n", nil},
+		{"merge", []PrinterOption{WithDuplicateAttrs(DupAttrMerge)},
+			"<t class='a b' id='y' style='color:red;margin:0' rel='r2'/>\n", nil},
+		{"merge custom", []PrinterOption{WithDuplicateAttrs(DupAttrMerge), WithAttrMerge("rel", " "), WithAttrMerge("class", ",")},
+			"<t class='a,b' id='y' style='color:red;margin:0' rel='r1 r2'/>\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil, append(tt.opts, WithErrorMode())...)
+			NewWriter(p).Tag("t", attrs)
+			if err := p.Close(); !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
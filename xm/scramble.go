@@ -1,48 +1,317 @@
 package xm
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 const AttrQuotationMark = '\''
 
+// RefStyle selects the syntax of character references produced by scramblers.
+type RefStyle int
+
+const (
+	// Accepted values for RefStyle:
+	RefNamed   = RefStyle(iota) // named entities for &<>'", hexadecimal references for other characters (default)
+	RefHex                      // hexadecimal references for all characters, e.g. &#x3C; and &#xA;
+	RefDecimal                  // decimal references for all characters, e.g. &#60; and &#10;
+)
+
 // ScrambleFunc is a generic string scrambler that replaces
 // codeunits matched by f with xml character references.
 func ScrambleFunc(s string, f func(byte) bool) []byte {
+	return ScrambleFuncRefs(s, f, RefNamed)
+}
+
+// ScrambleFuncRefs works like ScrambleFunc, producing character references
+// in the specified style. Control characters that are not allowed in XML 1.0,
+// even as references, are replaced with U+FFFD.
+func ScrambleFuncRefs(s string, f func(byte) bool, refs RefStyle) []byte {
+	b, _ := scramble_bytes(s, f, refs, false)
+	return b
+}
+
+// scramble_bytes replaces codeunits matched by f with character references.
+// Disallowed control characters are replaced with U+FFFD, or rejected with
+// *InvalidCharError.
+func scramble_bytes(s string, f func(byte) bool, refs RefStyle, reject bool) ([]byte, error) {
 	c, i := find_byte_func(s, f)
 	if i < 0 {
-		return []byte(s)
+		return []byte(s), nil
 	}
 	b := strings.Builder{}
 	b.Grow(len(s))
+	offset := 0
 	for {
 		b.WriteString(s[:i])
-		switch c {
+		switch {
+		case !control(c):
+			writeRef(&b, rune(c), refs)
+		case reject:
+			return nil, &InvalidCharError{Offset: offset + i, Char: rune(c)}
+		default:
+			b.WriteRune(utf8.RuneError)
+		}
+		s = s[i+1:]
+		offset += i + 1
+		c, i = find_byte_func(s, f)
+		if i < 0 {
+			break
+		}
+	}
+	b.WriteString(s)
+	return []byte(b.String()), nil
+}
+
+// control reports whether b is a C0 control character that is not allowed in
+// XML 1.0 documents.
+func control(b byte) bool {
+	return b < 0x20 && b != '\t' && b != '\n' && b != '\r'
+}
+
+// writeRef writes a character reference for r.
+func writeRef(b *strings.Builder, r rune, refs RefStyle) {
+	if refs == RefNamed {
+		switch r {
 		case '&':
 			b.WriteString("&amp;")
+			return
 		case '<':
 			b.WriteString("&lt;")
+			return
 		case '>':
 			b.WriteString("&gt;")
+			return
 		case '\'':
 			b.WriteString("&apos;")
+			return
 		case '"':
 			b.WriteString("&quot;")
-		default:
-			b.WriteByte('&')
-			b.WriteByte('#')
-			b.WriteByte(hex_chars[c>>4])
-			b.WriteByte(hex_chars[c&0b1111])
-			b.WriteByte(';')
+			return
 		}
-		s = s[i+1:]
-		c, i = find_byte_func(s, f)
-		if i < 0 {
-			break
+	}
+	if refs == RefDecimal {
+		b.WriteString("&#")
+		b.WriteString(strconv.Itoa(int(r)))
+	} else {
+		b.WriteString("&#x")
+		b.WriteString(strings.ToUpper(strconv.FormatInt(int64(r), 16)))
+	}
+	b.WriteByte(';')
+}
+
+// CharSet selects validation of characters against the Char production of the
+// XML specification.
+type CharSet int
+
+const (
+	// Accepted values for CharSet:
+	CharsUnchecked = CharSet(iota) // only C0 control characters are validated, text is processed byte by byte (default)
+	CharsXML10                     // characters allowed in XML 1.0 documents
+	CharsXML11                     // characters allowed in XML 1.1 documents, restricted characters, U+0085, and U+2028 are written as references
+)
+
+// InvalidAction selects how scramblers handle invalid characters.
+type InvalidAction int
+
+const (
+	// Accepted values for InvalidAction:
+	InvalidReplace = InvalidAction(iota) // replace invalid characters with U+FFFD (default)
+	InvalidReject                        // fail with *InvalidCharError
+)
+
+// InvalidCharError is returned by scramblers when they encounter invalid UTF-8
+// sequences, characters that are not allowed in XML, or characters that can
+// not be represented, see Scrambler.Verbatim.
+type InvalidCharError struct {
+	Offset int  // byte offset of the invalid character within the input
+	Char   rune // utf8.RuneError for invalid UTF-8 sequences
+}
+
+func (e *InvalidCharError) Error() string {
+	return fmt.Sprintf("xml: invalid character %U at offset %d", e.Char, e.Offset)
+}
+
+// Scrambler converts text into attribute values and content according to the
+// printer configuration. The zero value is equivalent to the ScrambleAttr and
+// ScrambleCont functions.
+type Scrambler struct {
+	// Quote is the quotation mark used for attribute values, either a single
+	// quote (default) or a double quote.
+	Quote byte
+
+	// Refs selects the syntax of character references.
+	Refs RefStyle
+
+	// Chars selects validation of characters, Invalid selects how invalid
+	// characters are handled.
+	Chars   CharSet
+	Invalid InvalidAction
+
+	// ASCII makes the scrambler write all non-ASCII characters as numeric
+	// character references, producing 7-bit safe output.
+	ASCII bool
+
+	// HTML selects HTML escaping: only the characters that are significant
+	// for HTML parsers are escaped, and U+00A0 is written as &nbsp; with the
+	// RefNamed style.
+	HTML bool
+
+	// RawText is the name of the enclosing HTML raw text element, like
+	// script or style. Content within raw text elements is written without
+	// escaping, and Cont fails with ErrRawTextEnd if the text contains the
+	// closing tag of the element. Character validation still applies.
+	RawText string
+}
+
+// Attr scrambles s for use as an attribute value.
+func (sc Scrambler) Attr(s string) (RawAttr, error) {
+	f := attr_scramble
+	switch {
+	case sc.HTML && sc.Quote == '"':
+		f = html_attr_scramble_dq
+	case sc.HTML:
+		f = html_attr_scramble
+	case sc.Quote == '"':
+		f = attr_scramble_dq
+	}
+	b, err := sc.scramble(s, f)
+	return RawAttr(b), err
+}
+
+// Cont scrambles s for use as content.
+func (sc Scrambler) Cont(s string) (RawCont, error) {
+	if sc.RawText != "" {
+		if containsCloseTag(s, sc.RawText) {
+			return nil, ErrRawTextEnd
 		}
+		sc.ASCII, sc.HTML = false, false
+		b, err := sc.scramble(s, func(byte) bool { return false })
+		return RawCont(b), err
 	}
-	b.WriteString(s)
-	return []byte(b.String())
+	b, err := sc.scramble(s, content_scramble)
+	return RawCont(b), err
+}
+
+func (sc Scrambler) scramble(s string, f func(byte) bool) ([]byte, error) {
+	if sc.Chars == CharsUnchecked && !sc.ASCII && !sc.HTML {
+		return scramble_bytes(s, f, sc.Refs, sc.Invalid == InvalidReject)
+	}
+
+	b := strings.Builder{}
+	done := 0 // s[:done] is already processed
+	for i := 0; i < len(s); {
+		r, n := rune(s[i]), 1
+		if r >= utf8.RuneSelf {
+			r, n = utf8.DecodeRuneInString(s[i:])
+		}
+
+		valid := !(r == utf8.RuneError && n == 1) && sc.valid(r)
+		ref := false
+		if valid {
+			ref = (r < utf8.RuneSelf && f(byte(r))) ||
+				(r >= utf8.RuneSelf && sc.ASCII) ||
+				(r == 0xA0 && sc.HTML) ||
+				(sc.Chars == CharsXML11 && (restricted(r) || xml11_eol(r)))
+		} else if sc.Invalid == InvalidReject {
+			return nil, &InvalidCharError{Offset: i, Char: r}
+		}
+
+		if !valid || ref {
+			if b.Len() == 0 {
+				b.Grow(len(s) + 16)
+			}
+			b.WriteString(s[done:i])
+			switch {
+			case r == 0xA0 && sc.HTML && sc.Refs == RefNamed:
+				b.WriteString("&nbsp;")
+			case valid:
+				writeRef(&b, r, sc.Refs)
+			case sc.ASCII:
+				writeRef(&b, utf8.RuneError, sc.Refs)
+			default:
+				b.WriteRune(utf8.RuneError)
+			}
+			done = i + n
+		}
+		i += n
+	}
+	if done == 0 {
+		return []byte(s), nil
+	}
+	b.WriteString(s[done:])
+	return []byte(b.String()), nil
+}
+
+// valid reports whether r matches the Char production for the scrambler's
+// character set.
+func (sc Scrambler) valid(r rune) bool {
+	switch sc.Chars {
+	case CharsXML10:
+		return r == '\t' || r == '\n' || r == '\r' || (0x20 <= r && r <= 0xD7FF) ||
+			(0xE000 <= r && r <= 0xFFFD) || (0x10000 <= r && r <= 0x10FFFF)
+	case CharsXML11:
+		return (0x1 <= r && r <= 0xD7FF) ||
+			(0xE000 <= r && r <= 0xFFFD) || (0x10000 <= r && r <= 0x10FFFF)
+	default:
+		return r >= 0x20 || !control(byte(r))
+	}
+}
+
+// restricted reports whether r matches the RestrictedChar production of XML
+// 1.1, these characters are only allowed as character references.
+func restricted(r rune) bool {
+	return (0x1 <= r && r <= 0x8) || r == 0xB || r == 0xC || (0xE <= r && r <= 0x1F) ||
+		(0x7F <= r && r <= 0x84) || (0x86 <= r && r <= 0x9F)
+}
+
+// xml11_eol reports whether r is normalized to a line feed by XML 1.1
+// parsers, such characters are written as references to preserve them.
+func xml11_eol(r rune) bool {
+	return r == 0x85 || r == 0x2028
+}
+
+// Verbatim validates s for use in comments, CDATA sections, processing
+// instructions, and doctypes, which can not contain character references.
+// Invalid characters are handled like in Attr and Cont. Restricted XML 1.1
+// characters, and non-ASCII characters in ASCII mode, can not be represented
+// and are handled like invalid ones, they are replaced with U+FFFD or '?' in
+// ASCII mode.
+func (sc Scrambler) Verbatim(s string) (string, error) {
+	reject := sc.Invalid == InvalidReject
+	if sc.Chars == CharsUnchecked && !sc.ASCII {
+		b, err := scramble_bytes(s, control, sc.Refs, reject)
+		return string(b), err
+	}
+
+	b := strings.Builder{}
+	done := 0 // s[:done] is already processed
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		ok := !(r == utf8.RuneError && n == 1) && sc.valid(r) &&
+			!(sc.ASCII && r >= utf8.RuneSelf) &&
+			!(sc.Chars == CharsXML11 && (restricted(r) || xml11_eol(r)))
+		if !ok {
+			if reject {
+				return "", &InvalidCharError{Offset: i, Char: r}
+			}
+			b.WriteString(s[done:i])
+			if sc.ASCII {
+				b.WriteByte('?')
+			} else {
+				b.WriteRune(utf8.RuneError)
+			}
+			done = i + n
+		}
+		i += n
+	}
+	if done == 0 {
+		return s, nil
+	}
+	b.WriteString(s[done:])
+	return b.String(), nil
 }
 
 // ScrambleAttr is a scrambler for attribute values.
@@ -55,8 +324,6 @@ func ScrambleCont(s string) RawCont {
 	return RawCont(ScrambleFunc(s, content_scramble))
 }
 
-const hex_chars = "0123456789abcdef"
-
 func find_byte_func(s string, f func(b byte) bool) (byte, int) {
 	i, n := 0, len(s)
 	for i < n {
@@ -74,6 +341,51 @@ func attr_scramble(b byte) bool {
 	return b < 0x20 || b == '<' || b == '&' || b == '>' || b == AttrQuotationMark
 }
 
+func attr_scramble_dq(b byte) bool {
+	return b < 0x20 || b == '<' || b == '&' || b == '>' || b == '"'
+}
+
 func content_scramble(b byte) bool {
-	return b == '<' || b == '&' || b == '>' || b == 0
+	return b == '<' || b == '&' || b == '>' || b == '\r' || control(b)
+}
+
+// HTML parsers do not normalize whitespace in attribute values, so only the
+// markup characters are escaped
+func html_attr_scramble(b byte) bool {
+	return b == '<' || b == '&' || b == '>' || b == AttrQuotationMark || b == '\r' || control(b)
+}
+
+func html_attr_scramble_dq(b byte) bool {
+	return b == '<' || b == '&' || b == '>' || b == '"' || b == '\r' || control(b)
+}
+
+// scrambleAttr scrambles s with the printer's Scrambler, errors are reported
+// with fail().
+func scrambleAttr(p Printer, s string) RawAttr {
+	r, err := scramblerOf(p).Attr(s)
+	if err != nil {
+		fail(p, err)
+	}
+	return r
+}
+
+// verbatimText validates s with the printer's Scrambler, errors are reported
+// with fail().
+func verbatimText(p Printer, s string) (string, bool) {
+	r, err := scramblerOf(p).Verbatim(s)
+	if err != nil {
+		fail(p, err)
+		return "", false
+	}
+	return r, true
+}
+
+// scrambleCont scrambles s with the printer's Scrambler, errors are reported
+// with fail().
+func scrambleCont(p Printer, s string) RawCont {
+	r, err := scramblerOf(p).Cont(s)
+	if err != nil {
+		fail(p, err)
+	}
+	return r
 }
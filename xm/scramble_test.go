@@ -1,6 +1,7 @@
 package xm
 
 import (
+	"encoding/xml"
 	"fmt"
 	"testing"
 )
@@ -13,9 +14,11 @@ func TestScramble(t *testing.T) {
 	}{
 		{"", "", ""},
 		{"abc", "abc", "abc"},
-		{"\x00", "&#00;", "&#00;"},
-		{"\t", "&#09;", "\t"},
-		{"\n", "&#0a;", "\n"},
+		{"\x00", "\uFFFD", "\uFFFD"},
+		{"\t", "&#x9;", "\t"},
+		{"\n", "&#xA;", "\n"},
+		{"\r", "&#xD;", "&#xD;"},
+		{"\x1f", "\uFFFD", "\uFFFD"},
 		{"<", "&lt;", "&lt;"},
 		{">", "&gt;", "&gt;"},
 		{"'", "&apos;", "'"},
@@ -39,3 +42,139 @@ func TestScramble(t *testing.T) {
 		})
 	}
 }
+
+func TestScramblerRefs(t *testing.T) {
+	tests := []struct {
+		sc        Scrambler
+		want_attr string
+		want_cont string
+	}{
+		{Scrambler{}, "&lt;&amp;&apos;\"&#x9;&#xA;", "&lt;&amp;'\"\t\n"},
+		{Scrambler{Quote: '"'}, "&lt;&amp;'&quot;&#x9;&#xA;", "&lt;&amp;'\"\t\n"},
+		{Scrambler{Refs: RefHex}, "&#x3C;&#x26;&#x27;\"&#x9;&#xA;", "&#x3C;&#x26;'\"\t\n"},
+		{Scrambler{Refs: RefDecimal}, "&#60;&#38;&#39;\"&#9;&#10;", "&#60;&#38;'\"\t\n"},
+	}
+	for _, tt := range tests {
+		attr, _ := tt.sc.Attr("<&'\"\t\n")
+		cont, _ := tt.sc.Cont("<&'\"\t\n")
+		got_attr, got_cont := string(attr), string(cont)
+		if got_attr != tt.want_attr || got_cont != tt.want_cont {
+			t.Errorf("%+v: Attr(), Cont() = %q, %q; want %q, %q",
+				tt.sc, got_attr, got_cont, tt.want_attr, tt.want_cont)
+		}
+	}
+}
+
+// TestScrambleConformance checks that all ASCII characters survive a round
+// trip through encoding/xml in both attributes and content, control
+// characters that are not allowed in XML 1.0 are replaced with U+FFFD.
+func TestScrambleConformance(t *testing.T) {
+	for _, quote := range []byte{'\'', '"'} {
+		for _, refs := range []RefStyle{RefNamed, RefHex, RefDecimal} {
+			sc := Scrambler{Quote: quote, Refs: refs}
+			for c := 0; c < 0x80; c++ {
+				s := "a" + string(rune(c)) + "b"
+				want := s
+				if control(byte(c)) {
+					want = "a\uFFFDb"
+				}
+				attr, _ := sc.Attr(s)
+				cont, _ := sc.Cont(s)
+				doc := "<x v=" + string(quote) + string(attr) + string(quote) + ">" +
+					string(cont) + "</x>"
+
+				v := struct {
+					V    string `xml:"v,attr"`
+					Text string `xml:",chardata"`
+				}{}
+				if err := xml.Unmarshal([]byte(doc), &v); err != nil {
+					t.Errorf("%+v, %#02x: %v in %q", sc, c, err, doc)
+					continue
+				}
+				if v.V != want || v.Text != want {
+					t.Errorf("%+v, %#02x: got %q, %q from %q", sc, c, v.V, v.Text, doc)
+				}
+			}
+		}
+	}
+}
+
+func TestScramblerChars(t *testing.T) {
+	tests := []struct {
+		name      string
+		sc        Scrambler
+		s         string
+		want_attr string
+		want_cont string
+		err       *InvalidCharError
+	}{
+		{"unchecked", Scrambler{}, "a\x01\uFFFEb", "a\uFFFD\uFFFEb", "a\uFFFD\uFFFEb", nil},
+		{"unchecked reject", Scrambler{Invalid: InvalidReject}, "a\uFFFE\x1b", "", "",
+			&InvalidCharError{Offset: 4, Char: 0x1B}},
+		{"xml10 replace", Scrambler{Chars: CharsXML10}, "a\x01\uFFFE\xffb\t",
+			"a\uFFFD\uFFFD\uFFFDb&#x9;", "a\uFFFD\uFFFD\uFFFDb\t", nil},
+		{"xml10 surrogate", Scrambler{Chars: CharsXML10}, "a\xed\xa0\x80b",
+			"a\uFFFD\uFFFD\uFFFDb", "a\uFFFD\uFFFD\uFFFDb", nil},
+		{"xml10 reject", Scrambler{Chars: CharsXML10, Invalid: InvalidReject}, "ab\u00e9\x0c", "", "",
+			&InvalidCharError{Offset: 4, Char: 0xC}},
+		{"xml10 reject utf8", Scrambler{Chars: CharsXML10, Invalid: InvalidReject}, "ab\xc3", "", "",
+			&InvalidCharError{Offset: 2, Char: 0xFFFD}},
+		{"xml11 restricted", Scrambler{Chars: CharsXML11}, "a\x01\u0085\u0086b\x00\u2028",
+			"a&#x1;&#x85;&#x86;b\uFFFD&#x2028;", "a&#x1;&#x85;&#x86;b\uFFFD&#x2028;", nil},
+		{"ascii", Scrambler{ASCII: true}, "caf\u00e9 \U0001F600<", "caf&#xE9; &#x1F600;&lt;",
+			"caf&#xE9; &#x1F600;&lt;", nil},
+		{"ascii decimal", Scrambler{ASCII: true, Refs: RefDecimal, Chars: CharsXML10}, "\u00e9\x02",
+			"&#233;&#65533;", "&#233;&#65533;", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attr, err_attr := tt.sc.Attr(tt.s)
+			cont, err_cont := tt.sc.Cont(tt.s)
+			if tt.err != nil {
+				for _, err := range []error{err_attr, err_cont} {
+					if e, ok := err.(*InvalidCharError); !ok || *e != *tt.err {
+						t.Errorf("got error %v, want %v", err, tt.err)
+					}
+				}
+				return
+			}
+			if err_attr != nil || err_cont != nil {
+				t.Fatalf("unexpected errors %v, %v", err_attr, err_cont)
+			}
+			if string(attr) != tt.want_attr || string(cont) != tt.want_cont {
+				t.Errorf("Attr(), Cont() = %q, %q; want %q, %q", attr, cont, tt.want_attr, tt.want_cont)
+			}
+		})
+	}
+}
+
+func TestScramblerVerbatim(t *testing.T) {
+	tests := []struct {
+		name string
+		sc   Scrambler
+		s    string
+		want string
+		err  *InvalidCharError
+	}{
+		{"unchecked", Scrambler{}, "a\x01\u00e9<", "a\uFFFD\u00e9<", nil},
+		{"xml10", Scrambler{Chars: CharsXML10}, "a\uFFFE\xffb", "a\uFFFD\uFFFDb", nil},
+		{"xml11", Scrambler{Chars: CharsXML11}, "a\x01\u0085\u2028b", "a\uFFFD\uFFFD\uFFFDb", nil},
+		{"ascii", Scrambler{ASCII: true}, "caf\u00e9\x02", "caf??", nil},
+		{"reject", Scrambler{Invalid: InvalidReject, ASCII: true}, "ab\u00e9", "",
+			&InvalidCharError{Offset: 2, Char: 0xE9}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sc.Verbatim(tt.s)
+			if tt.err != nil {
+				if ce, ok := err.(*InvalidCharError); !ok || *ce != *tt.err {
+					t.Errorf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Verbatim() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package xm
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// fieldFlags describe how a struct field is mapped into XML.
+type fieldFlags int
+
+const (
+	fElement fieldFlags = 1 << iota
+	fAttr
+	fCDATA
+	fCharData
+	fInnerXML
+	fComment
+	fAny
+	fOmitEmpty
+
+	fMode = fElement | fAttr | fCDATA | fCharData | fInnerXML | fComment | fAny
+)
+
+// fieldInfo holds details for the XML representation of a single field.
+type fieldInfo struct {
+	idx     []int
+	name    string
+	space   string   // namespace, only available in compatibility mode
+	parents []string // parent elements for 'a>b>c' paths
+	flags   fieldFlags
+}
+
+// typeInfo holds details for the XML representation of a struct type.
+type typeInfo struct {
+	name    string // element name, from the XMName/XMLName field or the type name
+	named   bool   // name is set with the tag of the XMName/XMLName field
+	space   string // element namespace, only available in compatibility mode
+	xmlname []int  // index of the XMLName field of type xml.Name, if any
+	fields  []fieldInfo
+}
+
+type typeKey struct {
+	typ    reflect.Type
+	compat bool
+}
+
+var tinfoMap sync.Map // map[typeKey]*typeInfo
+
+var xmlNameType = reflect.TypeOf(xml.Name{})
+
+// getTypeInfo returns the typeInfo structure with details necessary for
+// marshaling and unmarshaling typ. In compatibility mode, fields without `xm`
+// tags are configured with `xml` tags of the encoding/xml package.
+func getTypeInfo(typ reflect.Type, compat bool) *typeInfo {
+	key := typeKey{typ, compat}
+	if ti, ok := tinfoMap.Load(key); ok {
+		return ti.(*typeInfo)
+	}
+	ti := &typeInfo{name: typ.Name()}
+	if !compat || typ != xmlNameType {
+		// like in encoding/xml, xml.Name values have no fields
+		collectFields(ti, typ, nil, compat)
+	}
+	v, _ := tinfoMap.LoadOrStore(key, ti)
+	return v.(*typeInfo)
+}
+
+func collectFields(ti *typeInfo, typ reflect.Type, parent []int, compat bool) {
+	n := typ.NumField()
+	for i := 0; i < n; i++ {
+		f := typ.Field(i)
+		tag, tagged := f.Tag.Lookup("xm")
+		if !tagged && compat {
+			tag, tagged = f.Tag.Lookup("xml")
+		}
+		if tag == "-" {
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		idx := make([]int, len(parent)+1)
+		copy(idx, parent)
+		idx[len(parent)] = i
+
+		name, opts, _ := strings.Cut(tag, ",")
+		space := ""
+		if compat {
+			if i := strings.LastIndexByte(name, ' '); i >= 0 {
+				space, name = name[:i], name[i+1:]
+			}
+		}
+
+		if f.Name == "XMName" || f.Name == "XMLName" {
+			if name != "" {
+				ti.name, ti.space, ti.named = name, space, true
+			}
+			if f.Type == xmlNameType {
+				ti.xmlname = idx
+			}
+			continue
+		}
+
+		fi := fieldInfo{idx: idx, space: space}
+		inline := false
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "attr":
+				fi.flags |= fAttr
+			case "cdata":
+				fi.flags |= fCDATA
+			case "chardata":
+				fi.flags |= fCharData
+			case "innerxml":
+				fi.flags |= fInnerXML
+			case "comment":
+				fi.flags |= fComment
+			case "any":
+				fi.flags |= fAny
+			case "omitempty":
+				fi.flags |= fOmitEmpty
+			case "inline":
+				inline = true
+			}
+		}
+		switch fi.flags & fMode {
+		case 0:
+			fi.flags |= fElement
+		case fAny | fAttr:
+			// attribute wildcards are not supported
+			continue
+		}
+
+		// embedded structs without explicit names are inlined
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Struct && fi.flags&fMode == fElement &&
+			(inline || (f.Anonymous && name == "")) {
+			collectFields(ti, ft, idx, compat)
+			continue
+		}
+
+		if fi.flags&fElement != 0 {
+			if parents := strings.Split(name, ">"); len(parents) > 1 {
+				fi.parents, name = parents[:len(parents)-1], parents[len(parents)-1]
+			}
+		}
+		if name == "" && (fi.flags&fAny == 0 || compat) {
+			name = f.Name
+		}
+		fi.name = name
+		ti.fields = append(ti.fields, fi)
+	}
+}
+
+// nameOf returns the element name for the struct value val. The name is taken
+// from the value of the XMLName field, the tag of the XMName/XMLName field, or
+// the type name.
+func (ti *typeInfo) nameOf(val reflect.Value) (name, space string) {
+	if ti.xmlname != nil {
+		if fv, ok := fieldValue(val, ti.xmlname); ok {
+			if n := fv.Interface().(xml.Name); n.Local != "" {
+				return n.Local, n.Space
+			}
+		}
+	}
+	return ti.name, ti.space
+}
+
+// elementName returns the element name for the struct value val written by a
+// field with the specified name and space. Like in encoding/xml, the name set
+// with the tag or the value of the XMLName field takes precedence.
+func (ti *typeInfo) elementName(val reflect.Value, name, space string) (string, string) {
+	if ti.named {
+		return ti.name, ti.space
+	}
+	if ti.xmlname != nil {
+		if fv, ok := fieldValue(val, ti.xmlname); ok {
+			if n := fv.Interface().(xml.Name); n.Local != "" {
+				return n.Local, n.Space
+			}
+		}
+	}
+	return name, space
+}
+
+// fieldValue returns the value of the field described by idx, it returns false
+// if the field is reached through a nil embedded pointer.
+func fieldValue(val reflect.Value, idx []int) (reflect.Value, bool) {
+	for i, x := range idx {
+		if i > 0 {
+			if val.Kind() == reflect.Ptr {
+				if val.IsNil() {
+					return reflect.Value{}, false
+				}
+				val = val.Elem()
+			}
+		}
+		val = val.Field(x)
+	}
+	return val, true
+}
+
+// isEmptyValue reports whether v is considered empty for omitempty fields.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+package xm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+)
+
+// AttrUnmarshaler is the counterpart of AttrMarshaler, it allows user types to
+// customize how they are decoded from attribute values.
+type AttrUnmarshaler interface {
+	UnmarshalXAttr(string) error
+}
+
+// ContUnmarshaler is the counterpart of ContMarshaler, it allows user types to
+// customize how they are decoded from element content. UnmarshalXCont must
+// consume all tokens up to and including the end element matching start.
+type ContUnmarshaler interface {
+	UnmarshalXCont(d *Decoder, start xml.StartElement) error
+}
+
+var ErrNonPointer = errors.New("xml: decoding requires a non-nil pointer")
+
+// Decoder reads XML documents into values, it uses the same `xm` struct tags
+// that are used by Marshal.
+type Decoder struct {
+	x *xml.Decoder
+}
+
+// NewDecoder creates a new Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{x: xml.NewDecoder(r)}
+}
+
+// Unmarshal decodes the first element from data into v. See Decoder.Decode for
+// details.
+func Unmarshal(data []byte, v any) error {
+	return NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Token returns the next XML token from the input stream.
+func (d *Decoder) Token() (xml.Token, error) {
+	return d.x.Token()
+}
+
+// Skip reads tokens until it has consumed the end element matching the most
+// recent start element already consumed.
+func (d *Decoder) Skip() error {
+	return d.x.Skip()
+}
+
+// Decode reads the next element from the input stream and stores it in the
+// value pointed to by v. The mapping between XML and Go values mirrors the one
+// used by Marshal:
+//
+//   - struct fields are matched by their `xm` tags, attributes with the 'attr'
+//     option, child elements by name (including 'a>b>c' paths), text with the
+//     'chardata' and 'cdata' options, and comments with the 'comment' option
+//   - structs with a name in the tag of the XMName/XMLName field only accept
+//     elements with that name
+//   - slices receive one item per matching element, slice attributes are
+//     decoded from whitespace separated lists
+//   - nil pointers are allocated as needed
+//   - types implementing AttrUnmarshaler, ContUnmarshaler, or
+//     encoding.TextUnmarshaler decode themselves
+//   - strings, booleans, integer, and floating point values are parsed from
+//     text, surrounding whitespace and empty text are accepted for numbers and
+//     booleans
+//
+// Fields with the 'innerxml' option are not decoded.
+func (d *Decoder) Decode(v any) error {
+	return d.DecodeElement(v, nil)
+}
+
+// DecodeElement works like Decode, but it takes a pointer to the start element
+// that was already consumed from the input stream. This is useful in
+// ContUnmarshaler implementations.
+func (d *Decoder) DecodeElement(v any, start *xml.StartElement) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		return ErrNonPointer
+	}
+	if start == nil {
+		for {
+			tok, err := d.x.Token()
+			if err != nil {
+				return err
+			}
+			if se, ok := tok.(xml.StartElement); ok {
+				start = &se
+				break
+			}
+		}
+	}
+	return d.unmarshal(val.Elem(), *start)
+}
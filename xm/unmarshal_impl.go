@@ -0,0 +1,291 @@
+package xm
+
+import (
+	"encoding"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	attrUnmarshalerType = reflect.TypeOf((*AttrUnmarshaler)(nil)).Elem()
+	contUnmarshalerType = reflect.TypeOf((*ContUnmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// unmarshal decodes the element that begins with start into val, it consumes
+// all tokens up to and including the matching end element.
+func (d *Decoder) unmarshal(val reflect.Value, start xml.StartElement) error {
+	val = allocIndirect(val)
+
+	if val.CanAddr() {
+		pv := val.Addr()
+		if pv.Type().Implements(contUnmarshalerType) {
+			return pv.Interface().(ContUnmarshaler).UnmarshalXCont(d, start)
+		}
+		if pv.Type().Implements(textUnmarshalerType) {
+			s, err := d.text()
+			if err != nil {
+				return err
+			}
+			return pv.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	switch {
+	case val.Kind() == reflect.Interface:
+		return d.x.Skip()
+	case val.Kind() == reflect.Struct:
+		return d.unmarshal_struct(val, start)
+	case val.Kind() == reflect.Slice && !isBytes(val):
+		n := val.Len()
+		val.Set(reflect.Append(val, reflect.Zero(val.Type().Elem())))
+		if err := d.unmarshal(val.Index(n), start); err != nil {
+			val.SetLen(n)
+			return err
+		}
+		return nil
+	default:
+		s, err := d.text()
+		if err != nil {
+			return err
+		}
+		return unmarshal_text(val, s)
+	}
+}
+
+// text collects character data up to the end of the current element, nested
+// elements are skipped.
+func (d *Decoder) text() (string, error) {
+	b := strings.Builder{}
+	for {
+		tok, err := d.x.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.StartElement:
+			if err := d.x.Skip(); err != nil {
+				return "", err
+			}
+		case xml.EndElement:
+			return b.String(), nil
+		}
+	}
+}
+
+func (d *Decoder) unmarshal_struct(val reflect.Value, start xml.StartElement) error {
+	ti := getTypeInfo(val.Type(), false)
+	if ti.named && ti.name != start.Name.Local {
+		return fmt.Errorf("xml: expected element type <%s> but have <%s>", ti.name, start.Name.Local)
+	}
+	if ti.xmlname != nil {
+		fieldAlloc(val, ti.xmlname).Set(reflect.ValueOf(start.Name))
+	}
+	for _, a := range start.Attr {
+		for i := range ti.fields {
+			f := &ti.fields[i]
+			if f.flags&fAttr != 0 && f.name == a.Name.Local {
+				if err := unmarshal_attr(fieldAlloc(val, f.idx), a.Value); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return d.unmarshal_fields(val, ti, nil)
+}
+
+// unmarshal_fields decodes the content of the current element into the fields
+// of val. The prefix specifies the parent elements that were already consumed
+// for fields with 'a>b>c' paths.
+func (d *Decoder) unmarshal_fields(val reflect.Value, ti *typeInfo, prefix []string) error {
+	var text, comment strings.Builder
+	for {
+		tok, err := d.x.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if f := ti.find_element(prefix, t.Name.Local); f != nil {
+				err = d.unmarshal(fieldAlloc(val, f.idx), t)
+			} else if ti.has_parent(append(prefix, t.Name.Local)) {
+				err = d.unmarshal_fields(val, ti, append(prefix, t.Name.Local))
+			} else {
+				err = d.x.Skip()
+			}
+			if err != nil {
+				return err
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.Comment:
+			comment.Write(t)
+		case xml.EndElement:
+			if len(prefix) > 0 {
+				return nil
+			}
+			for i := range ti.fields {
+				f := &ti.fields[i]
+				switch f.flags & fMode {
+				case fCharData, fCDATA:
+					err = unmarshal_text(fieldAlloc(val, f.idx), text.String())
+				case fComment:
+					err = unmarshal_text(fieldAlloc(val, f.idx), comment.String())
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// find_element returns the field that receives the element with the specified
+// name, exact name matches take priority over fields with the 'any' option.
+func (ti *typeInfo) find_element(prefix []string, name string) *fieldInfo {
+	var wild *fieldInfo
+	for i := range ti.fields {
+		f := &ti.fields[i]
+		if f.flags&(fElement|fAny) == 0 || !equalNames(f.parents, prefix) {
+			continue
+		}
+		if f.name == name {
+			return f
+		}
+		if f.flags&fAny != 0 && wild == nil {
+			wild = f
+		}
+	}
+	return wild
+}
+
+// has_parent reports whether there is a field with a path that starts with
+// prefix.
+func (ti *typeInfo) has_parent(prefix []string) bool {
+	for i := range ti.fields {
+		f := &ti.fields[i]
+		if len(f.parents) >= len(prefix) && equalNames(f.parents[:len(prefix)], prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// allocIndirect dereferences pointers, allocating them if they are nil.
+func allocIndirect(val reflect.Value) reflect.Value {
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+	return val
+}
+
+// fieldAlloc returns the field described by idx, allocating nil embedded
+// pointers along the way.
+func fieldAlloc(val reflect.Value, idx []int) reflect.Value {
+	for i, x := range idx {
+		if i > 0 {
+			val = allocIndirect(val)
+		}
+		val = val.Field(x)
+	}
+	return val
+}
+
+func unmarshal_attr(val reflect.Value, s string) error {
+	val = allocIndirect(val)
+	if val.CanAddr() && val.Addr().Type().Implements(attrUnmarshalerType) {
+		return val.Addr().Interface().(AttrUnmarshaler).UnmarshalXAttr(s)
+	}
+	if val.Kind() == reflect.Slice && !isBytes(val) &&
+		!(val.CanAddr() && val.Addr().Type().Implements(textUnmarshalerType)) {
+		// space separated lists are decoded item by item
+		ff := strings.Fields(s)
+		v := reflect.MakeSlice(val.Type(), len(ff), len(ff))
+		for i, f := range ff {
+			if err := unmarshal_attr(v.Index(i), f); err != nil {
+				return err
+			}
+		}
+		val.Set(v)
+		return nil
+	}
+	return unmarshal_text(val, s)
+}
+
+// unmarshal_text parses s into val.
+func unmarshal_text(val reflect.Value, s string) error {
+	val = allocIndirect(val)
+	if val.CanAddr() && val.Addr().Type().Implements(textUnmarshalerType) {
+		return val.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	switch val.Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		// empty text decodes as zero
+		if s = strings.TrimSpace(s); s == "" {
+			val.Set(reflect.Zero(val.Type()))
+			return nil
+		}
+	}
+
+	switch val.Kind() {
+	case reflect.String:
+		val.SetString(s)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		val.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(s, 10, val.Type().Bits())
+		if err != nil {
+			return err
+		}
+		val.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v, err := strconv.ParseUint(s, 10, val.Type().Bits())
+		if err != nil {
+			return err
+		}
+		val.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, val.Type().Bits())
+		if err != nil {
+			return err
+		}
+		val.SetFloat(v)
+	case reflect.Slice:
+		if !isBytes(val) {
+			return &ErrUnsupportedType{val.Type()}
+		}
+		val.SetBytes([]byte(s))
+	default:
+		return &ErrUnsupportedType{val.Type()}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package xm
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type upper string
+
+func (u *upper) UnmarshalXAttr(s string) error {
+	*u = upper(strings.ToUpper(s))
+	return nil
+}
+
+type words []string
+
+func (ww *words) UnmarshalXCont(d *Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.CharData:
+			*ww = append(*ww, strings.Fields(string(t))...)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	in := Person{
+		Meta:   Meta{Created: "today"},
+		ID:     7,
+		Nick:   "jd",
+		Note:   " note ",
+		Name:   "John <Doe>",
+		Emails: []string{"a@example.com", "b@example.com"},
+		Home:   &Address{City: "Springfield", Street: "Main"},
+	}
+
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil))
+	Marshal(w, &in)
+
+	out := Person{}
+	if err := Unmarshal([]byte(buf.String()), &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip mismatch:\n got  %+v\n want %+v", out, in)
+	}
+}
+
+func TestUnmarshalListAttr(t *testing.T) {
+	type shape struct {
+		XMName struct{}  `xm:"shape"`
+		Class  []string  `xm:"class,attr"`
+		Box    []float64 `xm:"viewBox,attr"`
+		Codes  []upper   `xm:"codes,attr"`
+	}
+	out := shape{}
+	err := Unmarshal([]byte("<shape class=' a  b\tc ' viewBox='0 0 1.5 2' codes='x y'/>"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := shape{
+		Class: []string{"a", "b", "c"},
+		Box:   []float64{0, 0, 1.5, 2},
+		Codes: []upper{"X", "Y"},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("got  %+v\nwant %+v", out, want)
+	}
+
+	if err := Unmarshal([]byte("<shape viewBox='0 x'/>"), &out); err == nil {
+		t.Error("expected an error for an invalid list item")
+	}
+}
+
+func TestUnmarshalHooks(t *testing.T) {
+	type Doc struct {
+		Code  upper   `xm:"code,attr"`
+		Words words   `xm:"words"`
+		Lat   float64 `xm:"pos>lat"`
+		Lon   float64 `xm:"pos>lon"`
+		Flag  *bool   `xm:"flag"`
+		Text  string  `xm:",chardata"`
+	}
+
+	src := `<doc code='abc'>text<words> one two
+	three </words><pos><lat>1.5</lat><skip/><lon>-2</lon></pos><flag>true</flag></doc>`
+
+	got := Doc{}
+	if err := Unmarshal([]byte(src), &got); err != nil {
+		t.Fatal(err)
+	}
+	flag := true
+	want := Doc{Code: "ABC", Words: words{"one", "two", "three"}, Lat: 1.5, Lon: -2, Flag: &flag, Text: "text"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if err := Unmarshal([]byte("<doc><pos><lat>x</lat></pos></doc>"), &got); err == nil {
+		t.Errorf("expected a parse error")
+	}
+}
+
+func TestUnmarshalEmptyText(t *testing.T) {
+	type N struct {
+		A int     `xm:"a,attr"`
+		B bool    `xm:"b"`
+		F float64 `xm:"f"`
+		V int     `xm:",chardata"`
+	}
+	for _, src := range []string{`<n a=""></n>`, `<n a=" "><b/><f> </f>` + "\n  " + `</n>`} {
+		got := N{A: 1, V: 1}
+		if err := Unmarshal([]byte(src), &got); err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if got != (N{}) {
+			t.Errorf("%s: got %+v, want zero values", src, got)
+		}
+	}
+
+	got := N{}
+	if err := Unmarshal([]byte(`<n a=" 7 "><b> true </b> 3 </n>`), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := (N{A: 7, B: true, V: 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestUnmarshalRootName(t *testing.T) {
+	type N struct {
+		XMName struct{} `xm:"n"`
+		V      int      `xm:",chardata"`
+	}
+	got := N{}
+	if err := Unmarshal([]byte("<n>3</n>"), &got); err != nil || got.V != 3 {
+		t.Errorf("got %+v, %v", got, err)
+	}
+	err := Unmarshal([]byte("<other>3</other>"), &got)
+	if want := "xml: expected element type <n> but have <other>"; err == nil || err.Error() != want {
+		t.Errorf("got error %v, want %q", err, want)
+	}
+}
@@ -1,6 +1,8 @@
 package xm
 
 import (
+	"io"
+	"reflect"
 	"sort"
 )
 
@@ -9,14 +11,16 @@ type AttrWriter interface {
 	// Attr writes key='val' pair into tag's attributes. Accepted val types are:
 	//
 	//   - RawAttr, a special version of []byte that is written as-is:
-	//   - string, gets scrambled with the ScrambleAttr() function
+	//   - string, gets scrambled with the printer's Scrambler
 	//   - nils, or pointer types resolving to nil empty attribute
 	//   - types supporting AttrMarshaler interface are resolved as t.MarshalXAttr()
-	//   - types supporting encoding.TextMarshaler are marshaled into text, then scrambled with ScrambleAttr()
-	//   - boolean types are resolved to 'true' or 'false'
+	//   - types supporting encoding.TextMarshaler are marshaled into text, then scrambled with the printer's Scrambler
+	//   - boolean types are resolved to 'true' or 'false', in HTML mode true values are written as attributes without values and false values are omitted
 	//   - integer types are resolved to their decimal representation
 	//   - floating point types are converted to strings with strconv.FormatFloat using fmt='g' and prec=-1
-	//   - all other types will panic with ErrUnsupportedType
+	//   - []byte values are scrambled as text
+	//   - other slices and arrays of the above are written as space separated lists, use ListAttr for other separators
+	//   - all other types will fail with ErrUnsupportedType
 	Attr(string, any)
 
 	// OptAttr works similar to Attr, but it will skip writing the whole key='val'
@@ -28,7 +32,7 @@ type AttrWriter interface {
 	//   - types that support AttrMarshaler are considered empty if the bool part returned by t.MarshalXAttr() is false
 	//   - types that support encoding.TextMarshaler are considered empty if v.MarshalText() returns empty byte slice
 	//   - floating/integer/boolean types are never considered empty
-	//   - all other types will panic with ErrUnsupportedType
+	//   - all other types will fail with ErrUnsupportedType
 	OptAttr(string, any)
 
 	// Attrs writes map[string]any as attributes. The keys in the map are treated as
@@ -38,8 +42,23 @@ type AttrWriter interface {
 	Attrs(map[string]any)
 }
 
+// NSAttrWriter is an optional interface for writing namespace-qualified
+// attributes, it is implemented by the AttrWriter passed to func(AttrWriter)
+// arguments by the writers of this package. See also the NSAttr function.
+type NSAttrWriter interface {
+	// NSAttr works similar to Attr, but it writes a namespace-qualified
+	// attribute, see NSPrinter.NSAttr for details.
+	NSAttr(uri, local string, val any)
+}
+
 // ContWriter is an interface for writing content between tags.
 type ContWriter interface {
+	// Cont writes args into content. Slices and arrays are expanded item by
+	// item, as if the items were passed to Cont directly, except for byte
+	// slices, which are written as text. Maps are written as <key>value</key>
+	// tags in sorted key order, the keys must be valid XML names, otherwise
+	// Cont fails with ErrInvalidName. Use Entries for arbitrary keys and for
+	// other layouts.
 	Cont(...any)
 }
 
@@ -47,13 +66,102 @@ type ContWriter interface {
 type TagWriter interface {
 	// Tag writes an XML tag with attributes and content from args, where accepted arguments are:
 	//
-	//   - map[string]any - is written into tag attributes
+	//   - map[string]any - is written into tag attributes, sorted by key
+	//   - AttrList - is written into tag attributes in the list order
+	//   - TagOption - omits the tag if it stays empty, see OmitEmpty and OmitNoContent
 	//   - func(AttrWriter) - is written into tag attribute
 	//   - Attrs(map[string]T) - is processed into attributes, wrapped as func(AttrWriter)
+	//   - structs - tagged fields are written into attributes and content, see Marshal
 	//   - all types supported by ContWriter - written into tag content
 	Tag(string, ...any)
 }
 
+// NSTagWriter is an optional interface for writing namespace-qualified tags,
+// it is implemented by the writers of this package. See also the NSTag
+// function.
+type NSTagWriter interface {
+	// NSTag works similar to Tag, but it writes a namespace-qualified tag, see
+	// NSPrinter.NSOTag for details.
+	NSTag(uri, local string, args ...any)
+}
+
+// OptTagWriter is an optional interface for writing tags that are omitted if
+// they stay empty, it is implemented by the writers of this package. See also
+// the OptTag function.
+type OptTagWriter interface {
+	// OptTag works like Tag, but the tag is omitted if it gets no attributes
+	// and no content. It is equivalent to Tag with the OmitEmpty option.
+	OptTag(name string, args ...any)
+}
+
+// ElementWriter is an optional interface for writing tags that are closed
+// explicitly, it is implemented by the writers of this package. See also the
+// Begin function.
+type ElementWriter interface {
+	// Begin opens a tag and writes attributes and content from args, the same
+	// way as Tag does, but it leaves the tag open. More content can be written
+	// into the tag with subsequent calls, the tag is closed with the End
+	// method of the returned handle.
+	Begin(name string, args ...any) *Element
+}
+
+// TagOption values can be passed to TagWriter.Tag, NSTag, and Begin among
+// other arguments to omit tags that end up empty. Writing of such tags is
+// deferred until they get content, see OptTagPrinter.OptOTag.
+type TagOption int
+
+const (
+	// Accepted values for TagOption:
+	OmitEmpty     = TagOption(iota + 1) // omit the tag if it gets no attributes and no content
+	OmitNoContent                       // omit the tag if it gets no content, even if it has attributes
+)
+
+// Element is a handle for a tag opened with ElementWriter.Begin.
+type Element struct {
+	p      Printer
+	name   string
+	depth  int    // number of open tags, including this one
+	serial uint64 // serial number of the tag, 0 if not supported by the printer
+	ended  bool
+}
+
+// serialPrinter is implemented by printers that number the opened tags, this
+// allows Element to tell apart sibling tags with the same name.
+type serialPrinter interface {
+	tagSerial() uint64
+}
+
+// tagSerial returns the serial number of the innermost open tag, or 0.
+func tagSerial(p Printer) uint64 {
+	if sp, ok := p.(serialPrinter); ok {
+		return sp.tagSerial()
+	}
+	return 0
+}
+
+// End closes the tag. Tags must be closed in the reverse order of opening,
+// End fails with ErrEndOrder if any tags opened within this one are still
+// open, or if this tag was already closed by other means. The order is only
+// verified with printers that implement StackPrinter. Calling End more than
+// once has no effect, so it is safe to defer End and also call it explicitly.
+func (e *Element) End() {
+	if e.ended {
+		return
+	}
+	e.ended = true
+	if printerErr(e.p) != nil {
+		return
+	}
+	if sp, ok := e.p.(StackPrinter); ok {
+		if tags := sp.OpenTags(); len(tags) != e.depth || tags[e.depth-1] != e.name ||
+			tagSerial(e.p) != e.serial {
+			fail(e.p, ErrEndOrder)
+			return
+		}
+	}
+	e.p.CTag()
+}
+
 // Writer combines AttrWriter and TagWriter
 type Writer interface {
 	ContWriter
@@ -65,13 +173,59 @@ type Marshaler interface {
 	MarshalXM(Writer)
 }
 
+// WriterOption customizes the Writer created with NewWriter.
+type WriterOption func(*writer_impl)
+
+// WithXMLCompat enables compatibility with the encoding/xml package when
+// marshaling structs with reflection:
+//
+//   - fields without `xm` tags are configured with their `xml` tags
+//   - xml.Name fields, 'ns name' namespaces, 'a>b>c' paths, and the ',any' and
+//     ',cdata' options are supported
+//   - element names set with XMLName fields take precedence over field names,
+//     ',any' fields are named after the field
+//   - element namespaces are bound to the default namespace, see
+//     NSPrinter.PreferPrefix
+//   - types implementing xml.Marshaler and xml.MarshalerAttr are written with
+//     their MarshalXML and MarshalXMLAttr methods
+func WithXMLCompat() WriterOption {
+	return func(w *writer_impl) {
+		w.compat = true
+	}
+}
+
 // NewWriter wraps Printer p providing TagWriter API. Notice, that for a valid
 // XML document, you will need to write exactly one tag into it that becomes the
 // root.
-func NewWriter(p Printer) Writer {
-	return &writer_impl{p: p}
+func NewWriter(p Printer, opts ...WriterOption) Writer {
+	w := &writer_impl{p: p}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Render writes an XML document produced by f into out. The printer is created
+// with NewStreamPrinter in error mode with Indent2Spaces indentation,
+// additional options can be specified with opts. The document is finalized
+// with Close(). Render returns the first error that occurred while printing or
+// writing into out.
+func Render(out io.Writer, f func(Writer), opts ...PrinterOption) error {
+	p := NewStreamPrinter(out, Indent2Spaces, nil, append([]PrinterOption{WithErrorMode()}, opts...)...)
+	f(NewWriter(p))
+	return p.Close()
 }
 
+// AttrPair is a key-value pair of an AttrList.
+type AttrPair struct {
+	Key string
+	Val any
+}
+
+// AttrList is an ordered list of attributes that can be passed to TagWriter.
+// Unlike maps, the attributes are written in the list order.
+type AttrList []AttrPair
+
 // Attrs takes a generic map[string]T and turns it into a functor for writing
 // attributes that can be passed to TagWriter.
 func Attrs[M ~map[string]T, T any](m M) func(AttrWriter) {
@@ -98,3 +252,66 @@ func Tag(name string, args ...any) func(TagWriter) {
 		w.Tag(name, args...)
 	}
 }
+
+func OptTag(name string, args ...any) func(TagWriter) {
+	return func(w TagWriter) {
+		if ow, ok := w.(OptTagWriter); ok {
+			ow.OptTag(name, args...)
+		} else {
+			w.Tag(name, append(args[:len(args):len(args)], OmitEmpty)...)
+		}
+	}
+}
+
+// Entries takes a generic map and turns it into a functor for writing content
+// that can be passed to TagWriter. Map entries are written in sorted key order
+// as <item key='k'>value</item> tags, where item and key specify the tag name
+// and the key attribute name. Since the keys are written as attribute values,
+// they are not restricted to XML names.
+func Entries[M ~map[K]V, K comparable, V any](m M, item, key string) func(Writer) {
+	return func(w Writer) {
+		val := reflect.ValueOf(m)
+		for _, k := range mapKeys(val) {
+			w.Tag(item, Attr(key, k.Interface()), val.MapIndex(k).Interface())
+		}
+	}
+}
+
+// NSAttr creates a functor for writing a namespace-qualified attribute that
+// can be passed to TagWriter. It panics with ErrNotSupported if the
+// AttrWriter does not implement NSAttrWriter and uri is not empty.
+func NSAttr[T any](uri, local string, val T) func(AttrWriter) {
+	return func(w AttrWriter) {
+		if nw, ok := w.(NSAttrWriter); ok {
+			nw.NSAttr(uri, local, val)
+		} else if uri == "" {
+			w.Attr(local, val)
+		} else {
+			panic(ErrNotSupported)
+		}
+	}
+}
+
+// NSTag creates a functor for writing a namespace-qualified tag that can be
+// passed to TagWriter. It panics with ErrNotSupported if the TagWriter does
+// not implement NSTagWriter and uri is not empty.
+func NSTag(uri, local string, args ...any) func(TagWriter) {
+	return func(w TagWriter) {
+		if nw, ok := w.(NSTagWriter); ok {
+			nw.NSTag(uri, local, args...)
+		} else if uri == "" {
+			w.Tag(local, args...)
+		} else {
+			panic(ErrNotSupported)
+		}
+	}
+}
+
+// Begin opens a tag with w.Begin(), see ElementWriter. It panics with
+// ErrNotSupported if w does not implement ElementWriter.
+func Begin(w TagWriter, name string, args ...any) *Element {
+	if ew, ok := w.(ElementWriter); ok {
+		return ew.Begin(name, args...)
+	}
+	panic(ErrNotSupported)
+}
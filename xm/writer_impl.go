@@ -1,15 +1,29 @@
 package xm
 
 import (
+	"encoding/xml"
 	"reflect"
 	"sort"
 )
 
 type writer_impl struct {
-	p Printer
+	p      Printer
+	compat bool         // encoding/xml compatibility
+	enc    encoders     // custom encoders for the writer
+	vf     *valueFormat // nil for default formatting
 }
 
-func (w *writer_impl) attrEx(key string, val any, optional bool) {
+func (w *writer_impl) attrEx(uri, key string, val any, optional bool) {
+	if printerErr(w.p) != nil {
+		return
+	}
+	// custom encoders take precedence over the built-in handling
+	if f := w.attrEncoder(reflect.TypeOf(val)); f != nil {
+		raw, ok := f(reflect.ValueOf(val))
+		w.putAttr(uri, key, raw, ok, optional)
+		return
+	}
+
 	var raw RawAttr
 	var ok bool
 
@@ -18,37 +32,67 @@ func (w *writer_impl) attrEx(key string, val any, optional bool) {
 		raw, ok = v, len(v) > 0
 
 	case string:
-		raw = ScrambleAttr(v)
+		raw = scrambleAttr(w.p, v)
 		ok = len(raw) > 0
 
+	case bool:
+		if !scramblerOf(w.p).HTML {
+			s, _ := w.coreToStr(v)
+			raw, ok = w.attrStr(s), true
+		} else if v {
+			raw, ok = nil, true // boolean attribute without value
+		} else {
+			return
+		}
+
 	case func(AttrWriter):
 		// disregard optional flag in subfuncs
 		v(w)
 		return
 
 	default:
+		if w.compat {
+			if m, ok := val.(xml.MarshalerAttr); ok {
+				marshal_xml_attr(w, uri, key, m, optional)
+				return
+			}
+		}
 		var s string
-		if s, ok = coreToStr(val); ok {
-			raw = RawAttr(s)
+		if s, ok = w.coreToStr(val); ok {
+			raw = w.attrStr(s)
 		} else {
-			raw, ok = marshal_attr(reflect.ValueOf(val))
+			raw, ok = marshal_attr(w, reflect.ValueOf(val))
 		}
 	}
 
+	w.putAttr(uri, key, raw, ok, optional)
+}
+
+// putAttr writes an attribute, optional attributes are skipped if ok is false.
+func (w *writer_impl) putAttr(uri, key string, raw RawAttr, ok, optional bool) {
 	if optional && !ok {
 		return
 	}
-	w.p.Attr(key, raw)
+	if uri != "" {
+		nsAttr(w.p, uri, key, raw)
+	} else {
+		w.p.Attr(key, raw)
+	}
 }
 
 // Attr implements AttrWriter.Attr().
 func (w *writer_impl) Attr(key string, val any) {
-	w.attrEx(key, val, false)
+	w.attrEx("", key, val, false)
 }
 
 // OptAttr implements AttrWriter.OptAttr().
 func (w *writer_impl) OptAttr(key string, val any) {
-	w.attrEx(key, val, true)
+	w.attrEx("", key, val, true)
+}
+
+// NSAttr implements NSAttrWriter.NSAttr().
+func (w *writer_impl) NSAttr(uri, local string, val any) {
+	w.attrEx(uri, local, val, false)
 }
 
 // OptAttr implements AttrWriter.Attrs().
@@ -66,13 +110,23 @@ func (w *writer_impl) Attrs(aa map[string]any) {
 // Content implements ContentWriter.Content().
 func (w *writer_impl) Cont(args ...any) {
 	for _, arg := range args {
+		if printerErr(w.p) != nil {
+			return
+		}
+		// custom encoders take precedence over the built-in handling
+		if f := w.contEncoder(reflect.TypeOf(arg)); f != nil {
+			f(w.p, reflect.ValueOf(arg))
+			continue
+		}
 		switch a := arg.(type) {
 		case RawCont:
 			w.p.Content(a)
 		case Marshaler:
 			a.MarshalXM(w)
+		case ContMarshaler:
+			a.MarshalXCont(w.p)
 		case string:
-			w.p.Content(ScrambleCont(a))
+			w.p.Content(scrambleCont(w.p, a))
 		case func(ContWriter):
 			a(w)
 		case func(TagWriter):
@@ -82,10 +136,10 @@ func (w *writer_impl) Cont(args ...any) {
 		case func(Printer):
 			a(w.p)
 		default:
-			if r, ok := coreToStr(a); ok {
-				w.p.Content(RawCont(r))
+			if r, ok := w.coreToStr(a); ok {
+				w.p.Content(w.contStr(r))
 			} else {
-				marshal_content(w.p, reflect.ValueOf(a))
+				marshal_content(w, reflect.ValueOf(a))
 			}
 		}
 	}
@@ -93,27 +147,102 @@ func (w *writer_impl) Cont(args ...any) {
 
 // Tag implements TagWriter.Tag().
 func (w *writer_impl) Tag(name string, args ...any) {
-	w.p.OTag(name)
+	if printerErr(w.p) != nil {
+		return
+	}
+	w.otag(name, args)
 	defer w.p.CTag()
+	w.body(args)
+}
 
+// OptTag implements OptTagWriter.OptTag().
+func (w *writer_impl) OptTag(name string, args ...any) {
+	w.Tag(name, append(args[:len(args):len(args)], OmitEmpty)...)
+}
+
+// otag opens a tag, it is deferred if args contain TagOption values.
+func (w *writer_impl) otag(name string, args []any) {
+	if opt := tagOption(args); opt != 0 {
+		optOTag(w.p, name, opt == OmitNoContent)
+	} else {
+		w.p.OTag(name)
+	}
+}
+
+// nsotag works like otag for namespace-qualified tags.
+func (w *writer_impl) nsotag(uri, local string, args []any) {
+	opt := tagOption(args)
+	nsOTag(w.p, uri, local, opt != 0, opt == OmitNoContent)
+}
+
+// tagOption returns the strongest TagOption within args.
+func tagOption(args []any) TagOption {
+	var opt TagOption
+	for _, arg := range args {
+		if o, ok := arg.(TagOption); ok && o > opt {
+			opt = o
+		}
+	}
+	return opt
+}
+
+// NSTag implements NSTagWriter.NSTag().
+func (w *writer_impl) NSTag(uri, local string, args ...any) {
+	if printerErr(w.p) != nil {
+		return
+	}
+	w.nsotag(uri, local, args)
+	defer w.p.CTag()
+	w.body(args)
+}
+
+// Begin implements ElementWriter.Begin().
+func (w *writer_impl) Begin(name string, args ...any) *Element {
+	e := &Element{p: w.p, name: name}
+	if printerErr(w.p) != nil {
+		return e
+	}
+	w.otag(name, args)
+	if sp, ok := w.p.(StackPrinter); ok {
+		e.depth = len(sp.OpenTags())
+	}
+	e.serial = tagSerial(w.p)
+	w.body(args)
+	return e
+}
+
+// body writes attributes and content of a tag.
+func (w *writer_impl) body(args []any) {
 	// attributes
 	for _, arg := range args {
 		switch a := arg.(type) {
 		case map[string]any:
 			w.Attrs(a)
+		case AttrList:
+			for _, kv := range a {
+				w.Attr(kv.Key, kv.Val)
+			}
 		case func(AttrWriter):
 			a(w)
+		default:
+			if v, ok := w.structBody(a); ok {
+				marshal_struct_attrs(w, v)
+			}
 		}
 	}
 
 	// content
 	for _, arg := range args {
 		switch a := arg.(type) {
-		case map[string]any, func(AttrWriter):
+		case map[string]any, AttrList, func(AttrWriter), TagOption:
 			// skip attrs
 			continue
 		default:
-			w.Cont(a)
+			if v, ok := w.structBody(a); ok {
+				marshal_struct_cont(w, v)
+			} else {
+				w.Cont(a)
+			}
 		}
 	}
 }
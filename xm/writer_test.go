@@ -1,8 +1,12 @@
 package xm
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"testing"
 )
 
 type UserType struct{}
@@ -79,15 +83,16 @@ func ExampleWriter() {
 		// Marshaler
 		UserType{},
 
+		// comments and CDATA sections
+		Tag("div",
+			Comment("comments are handled as block level markup"),
+			Tag("p", "text with ", CData("<unescaped> & inline"), " sections")),
+
 		// low level printing
 		Tag("div", func(p Printer) {
 			p.Content(nil) // start new line
 			p.Linebreak()
 			p.Content(RawCont("direct raw writing with higher performance"))
-			p.OTag("p")
-			p.Content(RawCont("<!--and and flexibility-->"))
-			p.CTag()
-			p.Content(RawCont("<![CDATA[...]]>"))
 			p.Linebreak()
 			p.Content(ScrambleCont("make sure you pair OTag/CTag calls\nand avoid writing <things> that do not comply with XML syntax"))
 			p.StopInline() // make sure the following block level closing tag is indented and aligned nicely
@@ -121,12 +126,383 @@ func ExampleWriter() {
 	//   <p>can be nested</p>
 	//   <usertype k='v'>content</usertype>
 	//   <div>
+	//     <!--comments are handled as block level markup-->
+	//     <p>text with <![CDATA[<unescaped> & inline]]> sections</p>
+	//   </div>
+	//   <div>
 	//     direct raw writing with higher performance
-	//     <p><!--and and flexibility--></p>
-	//     <![CDATA[...]]>
 	//     make sure you pair OTag/CTag calls
 	//     and avoid writing &lt;things&gt; that do not comply with XML syntax
 	//   </div>
 	// </root>
 
 }
+
+func ExampleNSTag() {
+	const (
+		svg   = "http://www.w3.org/2000/svg"
+		xlink = "http://www.w3.org/1999/xlink"
+		ext   = "urn:example:ext"
+	)
+
+	buf := strings.Builder{}
+	p := NewPrinter(Indent2Spaces, func(s []byte) { buf.Write(s) }, nil)
+	p.PreferPrefix(svg, "")
+	p.PreferPrefix(xlink, "xlink")
+	w := NewWriter(p)
+
+	w.Cont(NSTag(svg, "svg",
+		NSTag(svg, "use", NSAttr(xlink, "href", "#a")),
+		NSTag(svg, "use", NSAttr(xlink, "href", "#b"), NSAttr(XMLNamespace, "lang", "en")),
+		NSTag(ext, "meta", NSTag(ext, "item"), NSTag("", "plain")),
+	))
+
+	fmt.Println(buf.String())
+
+	// Output:
+	// <svg xmlns='http://www.w3.org/2000/svg'>
+	//   <use xmlns:xlink='http://www.w3.org/1999/xlink' xlink:href='#a'/>
+	//   <use xmlns:xlink='http://www.w3.org/1999/xlink' xlink:href='#b' xml:lang='en'/>
+	//   <ns0:meta xmlns:ns0='urn:example:ext'>
+	//     <ns0:item/>
+	//     <plain xmlns=''/>
+	//   </ns0:meta>
+	// </svg>
+}
+
+func TestNSTagSiblings(t *testing.T) {
+	buf := strings.Builder{}
+	w := NewWriter(NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil))
+	w.Tag("root",
+		NSTag("urn:x", "item"),
+		NSTag("urn:y", "item", NSAttr("urn:x", "a", 1)),
+		NSTag("urn:x", "item", NSTag("urn:y", "item")),
+	)
+
+	want := "<root><ns0:item xmlns:ns0='urn:x'/>" +
+		"<ns1:item xmlns:ns1='urn:y' xmlns:ns0='urn:x' ns0:a='1'/>" +
+		"<ns0:item xmlns:ns0='urn:x'><ns1:item xmlns:ns1='urn:y'/></ns0:item></root>"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        func(Writer)
+		want     error
+		wantPath []string
+		wantOut  string
+	}{
+		{
+			name:    "ok",
+			f:       func(w Writer) { w.Tag("root", Attr("k", 1)) },
+			wantOut: "<root k='1'/>\n",
+		},
+		{
+			name: "nil attr",
+			f: func(w Writer) {
+				w.Tag("root", Attr[any]("k", nil), func(a AttrWriter) { a.OptAttr("o", nil) })
+			},
+			wantOut: "<root k=''/>\n",
+		},
+		{
+			name:     "unsupported attr",
+			f:        func(w Writer) { w.Tag("root", Tag("a", Attr("k", struct{}{}), "never written")) },
+			want:     &ErrUnsupportedType{},
+			wantPath: []string{"root", "a"},
+			wantOut:  "<root>\n  <a",
+		},
+		{
+			name: "misplaced attr",
+			f: func(w Writer) {
+				w.Tag("root", "text", func(p Printer) { p.Attr("k", nil) })
+			},
+			want:     ErrAttrPlacement,
+			wantPath: []string{"root"},
+			wantOut:  "<root>text",
+		},
+		{
+			name:     "unpaired CTag",
+			f:        func(w Writer) { w.Cont(func(p Printer) { p.CTag() }) },
+			want:     ErrUnpairedCTag,
+			wantPath: []string{},
+		},
+		{
+			name:     "invalid map key",
+			f:        func(w Writer) { w.Tag("root", map[string]int{"a": 1, "a b": 2}) },
+			want:     ErrInvalidName,
+			wantPath: []string{"root"},
+			wantOut:  "<root>\n  <a>1</a>",
+		},
+		{
+			name:     "numeric map key",
+			f:        func(w Writer) { w.Tag("root", map[int]int{1: 1}) },
+			want:     ErrInvalidName,
+			wantPath: []string{"root"},
+			wantOut:  "<root",
+		},
+		{
+			name:     "empty name",
+			f:        func(w Writer) { w.Tag("root", Tag("")) },
+			want:     ErrEmptyTagName,
+			wantPath: []string{"root"},
+			wantOut:  "<root",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			err := Render(&buf, tt.f)
+			if got := buf.String(); got != tt.wantOut {
+				t.Errorf("output = %q, want %q", got, tt.wantOut)
+			}
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			te, ok := err.(*TagError)
+			if !ok {
+				t.Fatalf("got %v, want *TagError", err)
+			}
+			if _, unsupported := tt.want.(*ErrUnsupportedType); unsupported {
+				if _, ok := te.Err.(*ErrUnsupportedType); !ok {
+					t.Errorf("got %v, want ErrUnsupportedType", te.Err)
+				}
+			} else if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if strings.Join(te.Path, "/") != strings.Join(tt.wantPath, "/") {
+				t.Errorf("path = %v, want %v", te.Path, tt.wantPath)
+			}
+		})
+	}
+}
+
+func TestRenderCharValidation(t *testing.T) {
+	buf := strings.Builder{}
+	err := Render(&buf, func(w Writer) {
+		w.Tag("root", Attr("k", "café"), "ok\x01")
+	}, WithCharValidation(CharsXML10, InvalidReplace), WithASCII())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, want := buf.String(), "<root k='caf&#xE9;'>ok&#xFFFD;</root>\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	err = Render(&buf, func(w Writer) {
+		w.Tag("root", Tag("a", "ok\x01"))
+	}, WithCharValidation(CharsXML10, InvalidReject))
+	var ce *InvalidCharError
+	if !errors.As(err, &ce) || ce.Offset != 2 || ce.Char != 1 {
+		t.Fatalf("got %v, want InvalidCharError at offset 2", err)
+	}
+	if te, ok := err.(*TagError); !ok || strings.Join(te.Path, "/") != "root/a" {
+		t.Errorf("got %v, want TagError at root/a", err)
+	}
+}
+
+func ExampleElement() {
+	Render(os.Stdout, func(w Writer) {
+		list := Begin(w, "list", Attr("kind", "numbers"))
+		defer list.End()
+		for i := 1; ; i++ {
+			if i > 3 {
+				w.Tag("more")
+				break
+			}
+			item := Begin(w, "item", Attr("n", i))
+			w.Cont(i * i)
+			item.End()
+		}
+	})
+	// Output:
+	// <list kind='numbers'>
+	//   <item n='1'>1</item>
+	//   <item n='2'>4</item>
+	//   <item n='3'>9</item>
+	//   <more/>
+	// </list>
+}
+
+func TestElementEnd(t *testing.T) {
+	tests := []struct {
+		name string
+		f    func(w Writer)
+		want error
+	}{
+		{"nested", func(w Writer) {
+			a := Begin(w, "a")
+			b := Begin(w, "b")
+			b.End()
+			b.End() // no effect
+			a.End()
+		}, nil},
+		{"mixed with Tag", func(w Writer) {
+			w.Tag("a", func(w Writer) {
+				b := Begin(w, "b")
+				defer b.End()
+				w.Tag("c")
+			})
+		}, nil},
+		{"unclosed child", func(w Writer) {
+			a := Begin(w, "a")
+			Begin(w, "b")
+			a.End()
+		}, ErrEndOrder},
+		{"closed by CTag", func(w Writer) {
+			a := Begin(w, "a")
+			w.Cont(func(p Printer) { p.CTag() })
+			Begin(w, "b")
+			a.End()
+		}, ErrEndOrder},
+		{"replaced sibling", func(w Writer) {
+			Begin(w, "root")
+			a := Begin(w, "a")
+			w.Cont(func(p Printer) { p.CTag(); p.OTag("b") })
+			a.End()
+		}, ErrEndOrder},
+		{"same name sibling", func(w Writer) {
+			Begin(w, "root")
+			a := Begin(w, "a")
+			w.Cont(func(p Printer) { p.CTag(); p.OTag("a") })
+			a.End()
+		}, ErrEndOrder},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Render(io.Discard, tt.f, WithAutoClose())
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// basicPrinter only implements the Printer interface, hiding the optional
+// interfaces of the wrapped printer.
+type basicPrinter struct {
+	Printer
+}
+
+func TestBasicPrinter(t *testing.T) {
+	buf := strings.Builder{}
+	w := NewWriter(basicPrinter{NewPrinter(IndentNone, func(s []byte) { buf.Write(s) }, nil)})
+	w.Tag("root", Attr("k", "a<b"), Comment("c"), NSTag("", "a", NSAttr("", "k", 1)),
+		OptTag("b"), func(w Writer) { Begin(w, "c").End() })
+
+	// tags can not be deferred without OptTagPrinter
+	want := "<root k='a&lt;b'><!--c--><a k='1'/><b/><c/></root>"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	defer func() {
+		if r := recover(); r != ErrNotSupported {
+			t.Errorf("recovered %v, want %v", r, ErrNotSupported)
+		}
+	}()
+	w.Cont(NSTag("urn:x", "a"))
+}
+
+func TestOptTag(t *testing.T) {
+	var nilptr *string
+	tests := []struct {
+		name string
+		f    func(w Writer)
+		want string
+	}{
+		{"empty", func(w Writer) { w.Cont(OptTag("a")) }, "<root v='1'/>\n"},
+		{"empty content", func(w Writer) { w.Cont(OptTag("a", "", nilptr)) }, "<root v='1'/>\n"},
+		{"attrs", func(w Writer) { w.Cont(OptTag("a", Attr("k", 1))) }, "<root v='1'>\n  <a k='1'/>\n</root>\n"},
+		{"optional attrs", func(w Writer) { w.Cont(OptTag("a", func(w AttrWriter) { w.OptAttr("k", "") })) },
+			"<root v='1'/>\n"},
+		{"attrs without content", func(w Writer) { w.Tag("a", Attr("k", 1), OmitNoContent) }, "<root v='1'/>\n"},
+		{"content", func(w Writer) { w.Tag("a", Attr("k", 1), OmitNoContent, "text") },
+			"<root v='1'>\n  <a k='1'>text</a>\n</root>\n"},
+		{"nested empty", func(w Writer) {
+			w.Cont(OptTag("a", Attr("k", 1), OptTag("b", OptTag("c")), Tag("d", OmitNoContent, Attr("k", 2))))
+		}, "<root v='1'>\n  <a k='1'/>\n</root>\n"},
+		{"nested content", func(w Writer) {
+			w.Cont(OptTag("a", OptTag("b", Attr("k", 1), OptTag("c", Comment("x"))), OptTag("d")))
+		}, "<root v='1'>\n  <a>\n    <b k='1'>\n      <c>\n        <!--x-->\n      </c>\n    </b>\n  </a>\n</root>\n"},
+		{"begin", func(w Writer) {
+			e := Begin(w, "a", OmitEmpty)
+			e.End()
+			e = Begin(w, "b", OmitEmpty)
+			w.Cont(1)
+			e.End()
+		}, "<root v='1'>\n  <b>1</b>\n</root>\n"},
+		{"preformatted", func(w Writer) {
+			w.Cont(OptTag("a", Attr("xml:space", "preserve"), Tag("b", "x")))
+		}, "<root v='1'>\n  <a xml:space='preserve'><b>x</b></a>\n</root>\n"},
+		{"namespaced empty", func(w Writer) { w.Cont(NSTag("urn:x", "a", OmitEmpty)) }, "<root v='1'/>\n"},
+		{"namespaced attrs", func(w Writer) { w.Cont(NSTag("urn:x", "a", Attr("k", 1), OmitNoContent)) },
+			"<root v='1'/>\n"},
+		{"namespaced content", func(w Writer) { w.Cont(NSTag("urn:x", "a", OmitEmpty, "text")) },
+			"<root v='1'>\n  <ns0:a xmlns:ns0='urn:x'>text</ns0:a>\n</root>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			err := Render(&buf, func(w Writer) { w.Tag("root", Attr("v", 1), tt.f) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContSequences(t *testing.T) {
+	type item struct {
+		XMName struct{} `xm:"item"`
+		ID     int      `xm:"id,attr"`
+	}
+	tests := []struct {
+		name string
+		arg  any
+		want string
+	}{
+		{"strings", []string{"a", "<b>"}, "<root>a&lt;b&gt;</root>"},
+		{"bytes", []byte("x&y"), "<root>x&amp;y</root>"},
+		{"raw", []RawCont{RawCont("<a/>"), RawCont("<b/>")}, "<root><a/><b/></root>"},
+		{"structs", []item{{ID: 1}, {ID: 2}}, "<root>\n  <item id='1'/>\n  <item id='2'/>\n</root>"},
+		{"array", [2]int{3, 4}, "<root>34</root>"},
+		{"funcs", []func(Writer){
+			func(w Writer) { w.Tag("a") },
+			func(w Writer) { w.Tag("b") },
+		}, "<root>\n  <a/>\n  <b/>\n</root>"},
+		{"mixed", []any{"x", Tag("a"), nil, Comment("c")}, "<root>x\n  <a/>\n  <!--c-->\n</root>"},
+		{"nested", [][]string{{"a", "b"}, {"c"}}, "<root>abc</root>"},
+		{"map", map[string]item{"b": {ID: 2}, "a": {ID: 1}},
+			"<root>\n  <a id='1'/>\n  <b id='2'/>\n</root>"},
+		{"map in cont", func(w Writer) { w.Cont(map[string]any{"b": 2, "a": "x"}) },
+			"<root>\n  <a>x</a>\n  <b>2</b>\n</root>"},
+		{"int keys", Entries(map[int]string{10: "x", 9: "y"}, "n", "k"),
+			"<root>\n  <n k='9'>y</n>\n  <n k='10'>x</n>\n</root>"},
+		{"arbitrary keys", Entries(map[string]int{"a b": 1, "1": 2}, "e", "k"),
+			"<root>\n  <e k='1'>2</e>\n  <e k='a b'>1</e>\n</root>"},
+		{"entries", Entries(map[string]int{"b": 2, "a": 1}, "entry", "name"),
+			"<root>\n  <entry name='a'>1</entry>\n  <entry name='b'>2</entry>\n</root>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := strings.Builder{}
+			err := Render(&buf, func(w Writer) { w.Tag("root", tt.arg) })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want+"\n" {
+				t.Errorf("got  %q\nwant %q", got, tt.want+"\n")
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package xm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"reflect"
+)
+
+var (
+	xmlMarshalerType     = reflect.TypeOf((*xml.Marshaler)(nil)).Elem()
+	xmlMarshalerAttrType = reflect.TypeOf((*xml.MarshalerAttr)(nil)).Elem()
+)
+
+// asXMLMarshaler returns the xml.Marshaler implemented by val or by a pointer
+// to val.
+func asXMLMarshaler(val reflect.Value) (xml.Marshaler, bool) {
+	if !implements(val, xmlMarshalerType) {
+		return nil, false
+	}
+	m, ok := valueArg(val).(xml.Marshaler)
+	return m, ok
+}
+
+// marshal_xml writes an element with m.MarshalXML() into raw content.
+func marshal_xml(w *writer_impl, space, name string, m xml.Marshaler) {
+	buf := bytes.Buffer{}
+	enc := xml.NewEncoder(&buf)
+	err := m.MarshalXML(enc, xml.StartElement{Name: xml.Name{Space: space, Local: name}})
+	if err == nil {
+		err = enc.Flush()
+	}
+	if err != nil {
+		fail(w.p, err)
+		return
+	}
+	w.p.Content(RawCont(buf.Bytes()))
+}
+
+// marshal_xml_attr writes an attribute with m.MarshalXMLAttr(), the attribute
+// is skipped if the returned name is empty. Optional attributes are also
+// skipped if the returned value is empty.
+func marshal_xml_attr(w *writer_impl, uri, key string, m xml.MarshalerAttr, optional bool) {
+	a, err := m.MarshalXMLAttr(xml.Name{Space: uri, Local: key})
+	if err != nil {
+		fail(w.p, err)
+		return
+	}
+	if a.Name.Local != "" {
+		raw := scrambleAttr(w.p, a.Value)
+		w.putAttr(a.Name.Space, a.Name.Local, raw, len(raw) > 0, optional)
+	}
+}